@@ -2,194 +2,391 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
+	"time"
+
+	"github.com/yojoots/taxlots/taxlot"
 )
 
-type Lot struct {
-	id       int
-	date     string
-	price    float64
-	quantity float64
-	txType   string
+// Function to print to stdout a descriptive error message and exit the script with a non-zero exit code
+func errorAndExit(errorMsg string) {
+	fmt.Printf("ERROR: %s\n\n", errorMsg)
+	printUsage()
+	os.Exit(1)
 }
 
-func (lot Lot) String() string {
-	return fmt.Sprintf("%d,%s,%.2f,%.8f", lot.id, lot.date, lot.price, lot.quantity)
+// Function to print to stdout the available algorithms, flags and an example invocation
+func printUsage() {
+	fmt.Printf("Usage: taxlots [flags] <algorithm>\n       taxlots [flags] -preview <date,price,quantity[,symbol[,fee[,feeCurrency[,lots[,account]]]]]>\n\n")
+	fmt.Printf("Available algorithms: %s\n\n", strings.Join(taxlot.LotSelectorNames(), ", "))
+	fmt.Printf("Flags:\n")
+	flag.PrintDefaults()
+	fmt.Printf("\nExample usage:\necho -e '2021-01-01,buy,10000.00,1.00000000\\n2021-02-01,sell,20000.00,0.50000000' | taxlots fifo\n")
 }
 
-// Function to calculate weighted price of lot in cases where multiple buys occurred on the same date
-func weightedPrice(oldLot Lot, newLot Lot) float64 {
-	quantityTotal := oldLot.quantity + newLot.quantity
-	oldLotWeight := oldLot.quantity / quantityTotal
-	newLotWeight := newLot.quantity / quantityTotal
-	return ((oldLot.price * oldLotWeight) + (newLot.price * newLotWeight))
+// Flags of the outputs written by processing the transaction log, which cannot be combined with modes that replace it
+var processingOutputFlags = []string{
+	"gains", "summary", "form8949", "form8949-box", "format", "unrealized", "price", "quotes", "valuation-date",
+	"harvest-loss", "harvest-max-gain",
 }
 
-// Function to print to stdout a descriptive error message and exit the script with a non-zero exit code
-func errorAndExit(errorMsg string) {
-	fmt.Printf("ERROR: %s\n\n", errorMsg)
-	fmt.Printf("Example usage:\necho -e '2021-01-01,buy,10000.00,1.00000000\\n2021-02-01,sell,20000.00,0.50000000' | taxlots fifo\n")
-	os.Exit(1)
+// Helper function to exit with an error if any of the named flags was passed along with the flag of mode, which does
+// something else instead (as described by instead), rather than silently ignoring them
+func rejectFlags(mode string, names []string, instead string) {
+	flag.Visit(func(passed *flag.Flag) {
+		for _, name := range names {
+			if passed.Name == name {
+				errorAndExit(fmt.Sprintf("Must not pass -%s with -%s, which %s", name, mode, instead))
+			}
+		}
+	})
 }
 
-// Function to execute a single sale transaction, subtracting saleQuantity from existing tax lots
-// Note: this function assumes that the lots are sorted such that the head of the slice is prioritized
-// which means that it is the responsibility of the calling function to sort lots before calling executeSale
-func executeSale(lots []Lot, saleQuantity float64) ([]Lot, error) {
-	for saleQuantity > 0 && len(lots) > 0 {
-		if lots[0].quantity > saleQuantity {
-			lots[0].quantity -= saleQuantity
-			saleQuantity = 0
-		} else if lots[0].quantity == saleQuantity {
-			lots = lots[1:]
-			saleQuantity = 0
-		} else {
-			// Reaching here means that lots[0].quantity < saleQuantity
-			saleQuantity -= lots[0].quantity
-			lots = lots[1:]
-		}
-	}
-	if saleQuantity > 0 {
-		// Reaching here means that input contained more sales than buys; interpret as erroneous
-		return nil, fmt.Errorf("Sale quantity exceeded total buy quantity; please ensure that transaction log input is valid")
-	}
-	return lots, nil
+// Helper function to create (or truncate) the file at path and hand it to write, making sure the file is closed afterwards
+func writeFile(path string, write func(out io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-// Function to parse a raw transaction string (in CSV format) into a Lot structure and txType (either "buy" or "sell")
-func parseRawTransaction(rawTx string, lotCount int) (Lot, error) {
-	txArray := strings.Split(rawTx, ",")
-	if len(txArray) != 4 {
-		return Lot{}, fmt.Errorf("Invalid tx format; incorrect argument count (should be 4, got %d): %s", len(txArray), rawTx)
+// Helper function to read opening lots from the file at path
+func readOpeningLots(path string) ([]taxlot.Lot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
+	return taxlot.ParseLots(file)
+}
 
-	txDate := txArray[0]
-	txType := strings.ToLower(txArray[1])
-	if txType != "buy" && txType != "sell" {
-		return Lot{}, fmt.Errorf("Invalid order type (must be either \"buy\" or \"sell\"): %s", txType)
+// Helper function to load market prices, either a single market price or the quotes in the file at quotesPath, along
+// with the date to value lots on: valuationDate or, if it is empty, the latest quote date or today
+func loadQuotes(marketPrice string, quotesPath string, valuationDate string) (quotes []taxlot.Quote, date time.Time, err error) {
+	if (marketPrice == "") == (quotesPath == "") {
+		return nil, date, fmt.Errorf("Market values require exactly one of -price or -quotes")
 	}
-	txPrice, err := strconv.ParseFloat(txArray[2], 64)
-	if err != nil {
-		return Lot{}, fmt.Errorf("Invalid (non-float) price: %s", txArray[2])
+	if valuationDate != "" {
+		if date, err = taxlot.ParseDate(valuationDate); err != nil {
+			return nil, date, err
+		}
 	}
-	txQuantity, err := strconv.ParseFloat(txArray[3], 64)
+
+	if quotesPath != "" {
+		file, err := os.Open(quotesPath)
+		if err != nil {
+			return nil, date, err
+		}
+		defer file.Close()
+		if quotes, err = taxlot.ParseQuotes(file); err != nil {
+			return nil, date, err
+		}
+		for _, quote := range quotes {
+			if valuationDate == "" && quote.Date.After(date) {
+				date = quote.Date
+			}
+		}
+	} else {
+		price, err := taxlot.ParseDecimal(marketPrice)
+		if err != nil || price.Sign() < 0 {
+			return nil, date, fmt.Errorf("Invalid market price (must be a non-negative number): %s", marketPrice)
+		}
+		if valuationDate == "" {
+			now := time.Now()
+			date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		// A quote without a symbol prices the lots of every asset
+		quotes = []taxlot.Quote{{Date: date, Price: price}}
+	}
+	return quotes, date, nil
+}
+
+// Helper function to parse a proposed sale, i.e. a raw sale transaction without its type column, in the format of
+// date,price,quantity[,symbol[,fee[,feeCurrency[,lots[,account]]]]]
+func parseProposedSale(rawSale string) (taxlot.Transaction, error) {
+	comma := strings.IndexByte(rawSale, ',')
+	if comma < 0 {
+		return taxlot.Transaction{}, fmt.Errorf("Invalid proposed sale format (should be date,price,quantity[,symbol[,fee[,feeCurrency[,lots[,account]]]]]): %s", rawSale)
+	}
+	sale, err := taxlot.ParseTransaction(rawSale[:comma] + ",sell" + rawSale[comma:])
 	if err != nil {
-		return Lot{}, fmt.Errorf("Invalid (non-float) quantity: %s", txArray[3])
+		return taxlot.Transaction{}, fmt.Errorf("Problem parsing proposed sale (%s): %s", rawSale, err.Error())
 	}
+	return sale, nil
+}
 
-	lot := Lot{
-		id:       lotCount + 1,
-		date:     txDate,
-		price:    txPrice,
-		quantity: txQuantity,
-		txType:   txType,
+// Helper function to read transactionLog from stdin
+// Skipped blank and comment lines are kept as empty entries, so that errors name the right line numbers
+func readTransactionLog(in io.Reader) (transactionLog []string, err error) {
+	scanner := taxlot.NewTransactionScanner(in)
+	for scanner.Scan() {
+		for len(transactionLog) < scanner.Line()-1 {
+			transactionLog = append(transactionLog, "")
+		}
+		transactionLog = append(transactionLog, scanner.Text())
 	}
+	return transactionLog, scanner.Err()
+}
 
-	return lot, nil
+// Helper function to read and parse a whole transaction log from in, sorting it by date if sortInput is set
+// Errors processing the transactions name their lines in the log as read, even once sorted
+func readTransactions(in io.Reader, sortInput bool, sameDate taxlot.SameDateOrder) ([]taxlot.Transaction, error) {
+	transactionLog, err := readTransactionLog(in)
+	if err != nil {
+		return nil, err
+	}
+	if sortInput {
+		return taxlot.SortTransactionLog(transactionLog, sameDate)
+	}
+	return taxlot.ParseTransactionLog(transactionLog)
 }
 
-// Function to process all transactions in a transaction log
-// transactions must be an array of CSV strings representing the raw transaction details, in chronological order
-// algorithm must be either "fifo" or "hifo"
-// Returns remaining lots after processing is complete
-func processTransactions(transactions []string, algorithm string) (lots []Lot, err error) {
-	// First check to ensure algorithm is valid
-	if algorithm != "fifo" && algorithm != "hifo" {
-		return nil, fmt.Errorf("Invalid algorithm (must be either \"fifo\" or \"hifo\"): %s", algorithm)
+func main() {
+	format := taxlot.OutputFormatCSV
+	flag.Var(&format, "format", "output format of the remaining lots (csv, json or ndjson)")
+	gainsPath := flag.String("gains", "", "write a realized gains record for every (partial) lot sold to this file")
+	summaryPath := flag.String("summary", "", "write realized gains totals per tax year and holding period to this file")
+	form8949Path := flag.String("form8949", "", "write realized gains as IRS Form 8949 shaped CSV to this file")
+	form8949Box := taxlot.DefaultForm8949Box
+	flag.Var(&form8949Box, "form8949-box", "Form 8949 short-term box (A, B or C) describing 1099-B reporting; long-term uses D, E or F respectively")
+	unrealizedPath := flag.String("unrealized", "", "write the market value and unrealized gain of every remaining lot to this file (requires -price or -quotes)")
+	harvestLoss := flag.String("harvest-loss", "", "print proposed sales of remaining lots realizing this loss (requires -price or -quotes) instead of the remaining lots")
+	harvestMaxGain := flag.String("harvest-max-gain", "", "print proposed sales of remaining lots realizing gains up to this amount (requires -price or -quotes) instead of the remaining lots")
+	marketPrice := flag.String("price", "", "with -unrealized or -harvest-*, the current market price of the remaining lots")
+	quotesPath := flag.String("quotes", "", "with -unrealized or -harvest-*, a file of market prices in the format of date,price[,symbol]")
+	valuationDate := flag.String("valuation-date", "", "with -unrealized or -harvest-*, the date to value remaining lots on (default: the latest quote date with -quotes, or today)")
+	openingPath := flag.String("opening", "", "start from the lots in this file (as printed in any -format), e.g. the lots remaining after the previous tax year")
+	sortInput := flag.Bool("sort", false, "sort transactions by date before processing, instead of requiring chronological input")
+	sameDate := taxlot.SameDateBuysFirst
+	flag.Var(&sameDate, "same-date-order", "with -sort, how transactions on the same date are ordered (buys-first, sells-first or input)")
+	washSales := flag.Bool("wash-sales", false, "detect wash sales, disallowing losses on sales with buys of the same asset within 30 days before or after them")
+	allowZeroPrice := flag.Bool("allow-zero-price", false, "accept buys at a price of zero, such as gifts received, which are otherwise rejected")
+	preview := flag.String("preview", "", "print the lots a proposed sale (date,price,quantity[,symbol[,fee[,feeCurrency[,lots[,account]]]]]) following the transaction log would consume, and its realized gain, under every algorithm instead of processing the log")
+	check := flag.Bool("check", false, "check the whole transaction log instead of processing it, listing every problem found with its line and column")
+	longTermThreshold := taxlot.DefaultLongTermThreshold
+	flag.Var(&longTermThreshold, "long-term-after", "holding period beyond which disposals are long-term (e.g. \"1y\", \"18m\", \"365d\")")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = printUsage
+	flag.Parse()
+
+	// Ensure that provided arguments are in expected format (previews cover every algorithm, so they take none)
+	var chosenAlgorithm string
+	if *preview != "" {
+		if flag.NArg() != 0 {
+			errorAndExit("Must not pass in a tax algorithm with -preview, which previews the sale under every algorithm")
+		}
+		rejectFlags("preview", append([]string{"check"}, processingOutputFlags...), "prints sale previews instead of processing the transaction log")
+	} else {
+		if flag.NArg() != 1 {
+			errorAndExit(fmt.Sprintf("Must pass in chosen tax algorithm (%s) as the only non-flag argument", taxlot.DescribeLotSelectors()))
+		}
+		chosenAlgorithm = flag.Arg(0)
+		if _, err := taxlot.LookupLotSelector(chosenAlgorithm); err != nil {
+			errorAndExit(err.Error())
+		}
+		if *check {
+			rejectFlags("check", processingOutputFlags, "checks the transaction log instead of processing it")
+		}
+	}
+
+	// The harvest target is checked along with the other flags, before any output is written
+	harvesting := *harvestLoss != "" || *harvestMaxGain != ""
+	var harvestTarget taxlot.Decimal
+	if harvesting {
+		if *harvestLoss != "" && *harvestMaxGain != "" {
+			errorAndExit("Only one of -harvest-loss or -harvest-max-gain may be passed")
+		}
+		rawTarget := *harvestMaxGain
+		if *harvestLoss != "" {
+			rawTarget = *harvestLoss
+		}
+		target, err := taxlot.ParseDecimal(rawTarget)
+		if err != nil || target.Sign() <= 0 {
+			errorAndExit(fmt.Sprintf("Invalid harvest amount (must be a positive number): %s", rawTarget))
+		}
+		harvestTarget = target
+		if *harvestLoss != "" {
+			// Losses are negative targets
+			harvestTarget = target.Neg()
+		}
 	}
 
-	// Loop through all transactions and process them in order
-	for _, tx := range transactions {
-		newLot, err := parseRawTransaction(tx, len(lots))
+	options := taxlot.Options{WashSales: *washSales, AllowZeroPriceBuys: *allowZeroPrice}
+	if *openingPath != "" {
+		openingLots, err := readOpeningLots(*openingPath)
 		if err != nil {
-			return nil, fmt.Errorf("Problem parsing raw transaction (%s): %s", tx, err.Error())
-		}
-		switch newLot.txType {
-		case "buy":
-			if len(lots) == 0 || lots[len(lots)-1].date != newLot.date {
-				// Buy lot with never-before-seen date
-				lots = append(lots, newLot)
-			} else {
-				// Buys on same date are aggregated into a single lot with a weighted-average price
-				lots[len(lots)-1].price = weightedPrice(lots[len(lots)-1], newLot)
-				lots[len(lots)-1].quantity += newLot.quantity
-			}
-		case "sell":
-			switch algorithm {
-			case "fifo":
-				// Subtract from lots[0]
-				// (we're assuming our transactions list is in chronological order)
-				lots, err = executeSale(lots, newLot.quantity)
-				if err != nil {
-					return nil, fmt.Errorf("Problem executing sale (fifo): %s", err.Error())
-				}
-			case "hifo":
-				// Execute hifo on a sorted-by-price list of lots
-				sort.SliceStable(lots, func(i, j int) bool {
-					return lots[i].price > lots[j].price
-				})
-				// Now that lots is sorted in highest-price-first order, execute the sale
-				lots, err = executeSale(lots, newLot.quantity)
-				if err != nil {
-					return nil, fmt.Errorf("Problem executing sale (hifo): %s", err.Error())
-				}
-				// After processing, sort lots back to default chronological ordering
-				sort.SliceStable(lots, func(i, j int) bool {
-					return lots[i].id < lots[j].id
-				})
-			default:
-				return nil, fmt.Errorf("Invalid algorithm (must be either \"fifo\" or \"hifo\"): %s", algorithm)
+			errorAndExit(fmt.Sprintf("Problem reading opening lots: %s", err.Error()))
+		}
+		options.OpeningLots = openingLots
+	}
+
+	// Preview a proposed sale under every algorithm instead of processing the transaction log, if requested
+	if *preview != "" {
+		sale, err := parseProposedSale(*preview)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		transactions, err := readTransactions(os.Stdin, *sortInput, sameDate)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		previews, err := taxlot.PreviewSale(transactions, sale, options)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		if err := taxlot.WriteSalePreviews(os.Stdout, previews, longTermThreshold); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing sale previews: %s", err.Error()))
+		}
+		return
+	}
+
+	// Check the transaction log instead of processing it, if requested
+	if *check {
+		var sortOrder taxlot.SameDateOrder
+		if *sortInput {
+			sortOrder = sameDate
+		}
+		problems, err := taxlot.Validate(os.Stdin, chosenAlgorithm, options, sortOrder)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s\n", problem.String())
+		}
+		if len(problems) != 0 {
+			fmt.Printf("ERROR: Found %d problem(s) in the transaction log\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("No problems found in the transaction log\n")
+		return
+	}
+
+	// Load market prices, if needed, before any output is written
+	var quotes []taxlot.Quote
+	var date time.Time
+	if *unrealizedPath != "" || harvesting {
+		var err error
+		if quotes, date, err = loadQuotes(*marketPrice, *quotesPath, *valuationDate); err != nil {
+			errorAndExit(err.Error())
+		}
+	}
+
+	// Realized gains (one record per lot consumed by each sale) are written as disposals are realized, and only kept in
+	// memory when Form 8949 rows are requested, so that large transaction logs can be streamed
+	var gainsFile *os.File
+	var gains *bufio.Writer
+	if *gainsPath != "" {
+		var err error
+		if gainsFile, err = os.Create(*gainsPath); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing realized gains: %s", err.Error()))
+		}
+		gains = bufio.NewWriter(gainsFile)
+	}
+	summarizer := taxlot.NewTaxYearSummarizer(longTermThreshold)
+	var disposals []taxlot.Disposal
+	handleDisposal := func(disposal taxlot.Disposal) error {
+		if gains != nil {
+			if err := taxlot.WriteDisposals(gains, []taxlot.Disposal{disposal}, longTermThreshold); err != nil {
+				return fmt.Errorf("Problem writing realized gains: %s", err.Error())
 			}
-		default:
-			return nil, fmt.Errorf("Invalid order type (must be either \"buy\" or \"sell\"): %s", newLot.txType)
 		}
+		summarizer.Add(disposal)
+		if *form8949Path != "" {
+			disposals = append(disposals, disposal)
+		}
+		return nil
 	}
-	return
-}
 
-// Helper function to read transactionLog from stdin
-func readTransactionLog(in io.Reader) (transactionLog []string) {
-	scanner := bufio.NewScanner(in)
-	for {
-		scanner.Scan()
-		// Store scanned string
-		text := scanner.Text()
-		if len(text) != 0 {
-			transactionLog = append(transactionLog, text)
-		} else {
-			// exit on empty string
-			break
-		}
-	}
-	return
-}
+	// Process transactions, streaming them from stdin unless they must all be read first (to sort them, for the uk
+	// algorithm, or to look back at every buy when harvesting)
+	var lots []taxlot.Lot
+	var transactions []taxlot.Transaction
+	if *sortInput || !taxlot.Streamable(chosenAlgorithm) || harvesting {
+		var err error
+		if transactions, err = readTransactions(os.Stdin, *sortInput, sameDate); err != nil {
+			errorAndExit(err.Error())
+		}
+		var realized []taxlot.Disposal
+		lots, realized, err = taxlot.Process(transactions, chosenAlgorithm, options)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		for _, disposal := range realized {
+			if err := handleDisposal(disposal); err != nil {
+				errorAndExit(err.Error())
+			}
+		}
+	} else {
+		var err error
+		lots, err = taxlot.ProcessStream(os.Stdin, chosenAlgorithm, options, handleDisposal)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+	}
+	if gains != nil {
+		err := gains.Flush()
+		if closeErr := gainsFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing realized gains: %s", err.Error()))
+		}
+	}
 
-func main() {
-	// Ensure that provided arguments are in expected format
-	if len(os.Args) != 2 {
-		errorAndExit("Must pass in chosen tax algorithm (\"fifo\" or \"hifo\") as first and only argument")
+	// Write realized gains totals per tax year, if requested
+	if *summaryPath != "" {
+		if err := writeFile(*summaryPath, func(out io.Writer) error {
+			return taxlot.WriteTaxYearSummary(out, summarizer.Summaries())
+		}); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing realized gains summary: %s", err.Error()))
+		}
 	}
-	chosenAlgorithm := os.Args[1]
-	if chosenAlgorithm != "fifo" && chosenAlgorithm != "hifo" {
-		errorAndExit(fmt.Sprintf("Invalid algorithm (must be either \"fifo\" or \"hifo\"): %s", chosenAlgorithm))
+
+	// Write realized gains as Form 8949 rows, if requested
+	if *form8949Path != "" {
+		if err := writeFile(*form8949Path, func(out io.Writer) error {
+			return taxlot.WriteForm8949(out, disposals, longTermThreshold, form8949Box)
+		}); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing Form 8949: %s", err.Error()))
+		}
 	}
 
-	// Read transactionLog from stdin
-	transactionLog := readTransactionLog(os.Stdin)
+	// Write the unrealized gains of the remaining lots, if requested
+	if *unrealizedPath != "" {
+		gains, err := taxlot.ValueLots(lots, quotes, date, longTermThreshold)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		if err := writeFile(*unrealizedPath, func(out io.Writer) error {
+			return taxlot.WriteUnrealizedGains(out, gains)
+		}); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing unrealized gains: %s", err.Error()))
+		}
+	}
 
-	// Process transactions
-	lots, err := processTransactions(transactionLog, chosenAlgorithm)
-	if err != nil {
-		errorAndExit(err.Error())
+	// Print proposed sales harvesting gains or losses instead of the remaining lots, if requested
+	if harvesting {
+		plan, err := taxlot.PlanHarvest(lots, transactions, quotes, date, harvestTarget, longTermThreshold)
+		if err != nil {
+			errorAndExit(err.Error())
+		}
+		if err := taxlot.WriteHarvestPlan(os.Stdout, plan); err != nil {
+			errorAndExit(fmt.Sprintf("Problem writing harvest plan: %s", err.Error()))
+		}
+		return
 	}
 
-	// Print results (remaining tax lots) after processing is complete, separated by newlines
-	for _, lot := range lots {
-		fmt.Printf("%s\n", lot.String())
+	// Print results (remaining tax lots) after processing is complete, in the chosen output format
+	if err := taxlot.WriteLots(os.Stdout, lots, format); err != nil {
+		errorAndExit(fmt.Sprintf("Problem writing remaining lots: %s", err.Error()))
 	}
 }
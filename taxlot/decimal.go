@@ -0,0 +1,194 @@
+package taxlot
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Number of digits tracked after the decimal point (satoshi-level precision)
+const decimalPlaces = 8
+
+// A Decimal is an exact fixed-point number, stored as an arbitrary-precision count of 10^-decimalPlaces units
+// Because the count is arbitrary-precision, sums and products of amounts of any magnitude cannot overflow
+// Decimals are immutable: arithmetic goes through methods (Add, Sub, Neg, Mul, Div) returning a new Decimal,
+// and comparison goes through Cmp, Sign and IsZero (the built-in operators, including ==, do not apply)
+// The zero value is zero
+//
+// Rounding policy: whenever a result has more digits than can be represented, it is rounded to the nearest
+// representable value, with ties rounded to the nearest even value ("banker's rounding")
+type Decimal struct {
+	_     [0]func() // Prevents comparison with ==, which would compare pointers rather than values
+	units *big.Int  // Never modified once set, and nil for zero (such that equal Decimals are deeply equal)
+}
+
+// Multiplier converting a whole number into Decimal units (10^decimalPlaces)
+var decimalScale = big.NewInt(100000000)
+
+// The smallest positive Decimal (10^-decimalPlaces)
+var decimalUnit = Decimal{units: big.NewInt(1)}
+
+// Function to convert a whole number into a Decimal
+func DecimalFromInt(n int64) Decimal {
+	return newDecimal(new(big.Int).Mul(big.NewInt(n), decimalScale))
+}
+
+// Helper function to wrap a count of Decimal units, which must not be modified afterwards
+func newDecimal(units *big.Int) Decimal {
+	if units.Sign() == 0 {
+		return Decimal{}
+	}
+	return Decimal{units: units}
+}
+
+// Function to parse a plain decimal string (e.g. "10000.00" or "-0.5") into a Decimal
+// Values with more than decimalPlaces fractional digits are rounded according to the rounding policy
+func ParseDecimal(s string) (Decimal, error) {
+	digits := s
+	negative := false
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	wholeDigits, fractionDigits := digits, ""
+	if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+		wholeDigits, fractionDigits = digits[:dot], digits[dot+1:]
+	}
+	if len(wholeDigits)+len(fractionDigits) == 0 {
+		return Decimal{}, fmt.Errorf("Invalid decimal: %q", s)
+	}
+	for _, r := range wholeDigits + fractionDigits {
+		if r < '0' || r > '9' {
+			return Decimal{}, fmt.Errorf("Invalid decimal: %q", s)
+		}
+	}
+
+	// Pad (or round away) the fractional digits such that the digit string is a count of Decimal units
+	units, _ := new(big.Int).SetString(wholeDigits+fractionDigits, 10)
+	if negative {
+		units.Neg(units)
+	}
+	denominator := big.NewInt(1)
+	if len(fractionDigits) < decimalPlaces {
+		units.Mul(units, pow10(decimalPlaces-len(fractionDigits)))
+	} else {
+		denominator = pow10(len(fractionDigits) - decimalPlaces)
+	}
+	return roundQuotient(units, denominator), nil
+}
+
+// Helper function to compute 10^exponent
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+// Helper function to compute numerator / denominator as a count of Decimal units, according to the rounding policy
+func roundQuotient(numerator *big.Int, denominator *big.Int) Decimal {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() != 0 {
+		// Compare twice the remainder against the denominator to decide which way to round
+		twiceRemainder := new(big.Int).Abs(remainder)
+		twiceRemainder.Lsh(twiceRemainder, 1)
+		comparison := twiceRemainder.CmpAbs(denominator)
+		if comparison > 0 || (comparison == 0 && quotient.Bit(0) == 1) {
+			if (numerator.Sign() < 0) != (denominator.Sign() < 0) {
+				quotient.Sub(quotient, big.NewInt(1))
+			} else {
+				quotient.Add(quotient, big.NewInt(1))
+			}
+		}
+	}
+	return newDecimal(quotient)
+}
+
+// Helper function to access the count of Decimal units of d, which must not be modified
+func (d Decimal) bigInt() *big.Int {
+	if d.units == nil {
+		return new(big.Int)
+	}
+	return d.units
+}
+
+// Add returns d + other
+func (d Decimal) Add(other Decimal) Decimal {
+	return newDecimal(new(big.Int).Add(d.bigInt(), other.bigInt()))
+}
+
+// Sub returns d - other
+func (d Decimal) Sub(other Decimal) Decimal {
+	return newDecimal(new(big.Int).Sub(d.bigInt(), other.bigInt()))
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return newDecimal(new(big.Int).Neg(d.bigInt()))
+}
+
+// Cmp returns -1, 0 or +1 depending on whether d is less than, equal to or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	return d.bigInt().Cmp(other.bigInt())
+}
+
+// Helper function to compute the fractional part of d, which has the same sign as d (e.g. -0.5 for -2.5)
+func (d Decimal) fraction() Decimal {
+	return newDecimal(new(big.Int).Rem(d.bigInt(), decimalScale))
+}
+
+// Sign returns -1, 0 or +1 depending on whether d is negative, zero or positive
+func (d Decimal) Sign() int {
+	return d.bigInt().Sign()
+}
+
+// IsZero reports whether d is zero
+func (d Decimal) IsZero() bool {
+	return d.units == nil
+}
+
+// Mul returns d * other, rounded according to the rounding policy
+func (d Decimal) Mul(other Decimal) Decimal {
+	product := new(big.Int).Mul(d.bigInt(), other.bigInt())
+	return roundQuotient(product, decimalScale)
+}
+
+// Div returns d / other, rounded according to the rounding policy
+// Dividing by zero is a programming error and panics
+func (d Decimal) Div(other Decimal) Decimal {
+	if other.IsZero() {
+		panic("decimal division by zero")
+	}
+	scaled := new(big.Int).Mul(d.bigInt(), decimalScale)
+	return roundQuotient(scaled, other.bigInt())
+}
+
+// proRata returns the share of d corresponding to part out of whole (d * part / whole), rounded according to the rounding policy
+// A whole of zero yields a zero share
+func (d Decimal) proRata(part Decimal, whole Decimal) Decimal {
+	if whole.IsZero() {
+		return Decimal{}
+	}
+	product := new(big.Int).Mul(d.bigInt(), part.bigInt())
+	return roundQuotient(product, whole.bigInt())
+}
+
+// StringFixed formats d with exactly places digits after the decimal point, rounding according to the rounding policy
+func (d Decimal) StringFixed(places int) string {
+	if places > decimalPlaces {
+		places = decimalPlaces
+	}
+	units := roundQuotient(d.bigInt(), pow10(decimalPlaces-places)).bigInt()
+
+	sign := ""
+	if units.Sign() < 0 {
+		sign = "-"
+	}
+	digits := fmt.Sprintf("%0*s", places+1, new(big.Int).Abs(units).String())
+	if places == 0 {
+		return sign + digits
+	}
+	return sign + digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+}
+
+// String formats d with full precision (decimalPlaces digits after the decimal point)
+func (d Decimal) String() string {
+	return d.StringFixed(decimalPlaces)
+}
@@ -0,0 +1,147 @@
+package taxlot
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Helper function to parse a Decimal literal in tests, panicking on malformed input
+func mustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64 // Count of Decimal units
+	}{
+		{"0", 0},
+		{"1", 100000000},
+		{"1.", 100000000},
+		{".5", 50000000},
+		{"10000.00", 1000000000000},
+		{"-0.5", -50000000},
+		{"+2.25", 225000000},
+		{"0.00000001", 1},
+		{"0.123456789", 12345679},
+		{"0.000000005", 0},
+		{"0.000000015", 2},
+		{"-0.000000015", -2},
+		{"92233720368.54775807", 9223372036854775807},
+	}
+	for _, testCase := range testCases {
+		result, err := ParseDecimal(testCase.input)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): Unexpected error: %s", testCase.input, err.Error())
+			continue
+		}
+		if result.bigInt().Cmp(big.NewInt(testCase.expected)) != 0 {
+			t.Errorf("ParseDecimal(%q): Expected %d units ... got %s instead", testCase.input, testCase.expected, result.bigInt())
+		}
+	}
+
+	// Amounts beyond the range of an int64 count of units are represented exactly
+	for _, input := range []string{"92233720368.54775808", "-120000000000.00000001", "123456789012345678901234567890.50000000"} {
+		if result, err := ParseDecimal(input); err != nil || result.String() != strings.TrimPrefix(input, "+") {
+			t.Errorf("ParseDecimal(%q): Expected %s ... got %s (error: %v) instead", input, input, result, err)
+		}
+	}
+
+	for _, badInput := range []string{"", ".", "-", "1e5", "NaN", "Inf", "1,000", "1.2.3", " 1", "0x10"} {
+		if _, err := ParseDecimal(badInput); err == nil {
+			t.Errorf("ParseDecimal(%q): Expected an error, but none resulted", badInput)
+		}
+	}
+}
+
+func TestDecimalStringFixed(t *testing.T) {
+	testCases := []struct {
+		value    Decimal
+		places   int
+		expected string
+	}{
+		{mustParseDecimal("10000"), 2, "10000.00"},
+		{mustParseDecimal("0.5"), 8, "0.50000000"},
+		{mustParseDecimal("-1234.5678"), 2, "-1234.57"},
+		{mustParseDecimal("0.125"), 2, "0.12"},
+		{mustParseDecimal("0.135"), 2, "0.14"},
+		{mustParseDecimal("-0.125"), 2, "-0.12"},
+		{mustParseDecimal("0.004"), 2, "0.00"},
+		{mustParseDecimal("2.5"), 0, "2"},
+		{mustParseDecimal("3.5"), 0, "4"},
+	}
+	for _, testCase := range testCases {
+		if result := testCase.value.StringFixed(testCase.places); result != testCase.expected {
+			t.Errorf("StringFixed(%d) of %s: Expected %s ... got %s instead", testCase.places, testCase.value, testCase.expected, result)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	if result := mustParseDecimal("0.1").Add(mustParseDecimal("0.2")); result.Cmp(mustParseDecimal("0.3")) != 0 {
+		t.Errorf("Expected 0.1 + 0.2 to be exactly 0.3 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("1025000.00").Mul(mustParseDecimal("0.0025")); result.Cmp(mustParseDecimal("2562.5")) != 0 {
+		t.Errorf("Expected 1025000.00 * 0.0025 to be 2562.5 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("0.00000001").Mul(mustParseDecimal("0.5")); !result.IsZero() {
+		t.Errorf("Expected 0.00000001 * 0.5 to round half-even to 0 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("0.00000003").Mul(mustParseDecimal("0.5")); result.Cmp(mustParseDecimal("0.00000002")) != 0 {
+		t.Errorf("Expected 0.00000003 * 0.5 to round half-even to 0.00000002 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("1").Div(mustParseDecimal("3")); result.Cmp(mustParseDecimal("0.33333333")) != 0 {
+		t.Errorf("Expected 1 / 3 to be 0.33333333 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("-2").Div(mustParseDecimal("3")); result.Cmp(mustParseDecimal("-0.66666667")) != 0 {
+		t.Errorf("Expected -2 / 3 to be -0.66666667 ... got %s instead", result)
+	}
+}
+
+func TestDecimalBeyondInt64(t *testing.T) {
+	// Sums and products of amounts whose count of units exceeds an int64 must not overflow
+	quantity := mustParseDecimal("60000000000")
+	if result := quantity.Add(quantity); result.String() != "120000000000.00000000" {
+		t.Errorf("Expected 60000000000 + 60000000000 to be 120000000000 ... got %s instead", result)
+	}
+	if result := quantity.Neg().Sub(quantity); result.String() != "-120000000000.00000000" {
+		t.Errorf("Expected -60000000000 - 60000000000 to be -120000000000 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("100000").Mul(mustParseDecimal("1000000")); result.String() != "100000000000.00000000" {
+		t.Errorf("Expected 100000 * 1000000 to be 100000000000 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("100000000000").Div(mustParseDecimal("0.00000001")); result.String() != "10000000000000000000.00000000" {
+		t.Errorf("Expected 100000000000 / 0.00000001 to be 10000000000000000000 ... got %s instead", result)
+	}
+	if result := mustParseDecimal("120000000000.5").StringFixed(0); result != "120000000000" {
+		t.Errorf("Expected 120000000000.5 to round half-even to 120000000000 ... got %s instead", result)
+	}
+}
+
+func TestDecimalComparison(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1", "2", -1},
+		{"2", "1", 1},
+		{"1.0", "1.00000000", 0},
+		{"-0", "0", 0},
+		{"-120000000000", "92233720368", -1},
+	}
+	for _, testCase := range testCases {
+		if result := mustParseDecimal(testCase.a).Cmp(mustParseDecimal(testCase.b)); result != testCase.expected {
+			t.Errorf("Cmp(%s, %s): Expected %d ... got %d instead", testCase.a, testCase.b, testCase.expected, result)
+		}
+	}
+	// Equal values are deeply equal, whichever way they were computed
+	if difference := mustParseDecimal("0.3").Sub(mustParseDecimal("0.3")); !reflect.DeepEqual(difference, Decimal{}) || !difference.IsZero() {
+		t.Errorf("Expected 0.3 - 0.3 to be deeply equal to the zero Decimal ... got %#v instead", difference)
+	}
+}
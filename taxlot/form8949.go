@@ -0,0 +1,153 @@
+package taxlot
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Date format used on IRS Form 8949
+const form8949DateLayout = "01/02/2006"
+
+// Short-term Form 8949 boxes (Part I), and the Schedule D line each box's totals are reported on
+// Long-term boxes (Part II) are the short-term box letter plus three (A→D, B→E, C→F)
+var form8949ShortTermBoxes = map[string]string{"A": "1b", "B": "2", "C": "3"}
+var form8949LongTermBoxes = map[string]string{"D": "8b", "E": "9", "F": "10"}
+
+// A Form8949Box is the checkbox at the top of each Form 8949 part, describing how the transactions were reported on Form 1099-B
+type Form8949Box string
+
+// Default to box C/F: transactions not reported to the taxpayer on Form 1099-B
+const DefaultForm8949Box = Form8949Box("C")
+
+func (box Form8949Box) String() string {
+	return string(box)
+}
+
+// Set implements flag.Value, accepting the short-term box letter (A, B or C)
+func (box *Form8949Box) Set(s string) error {
+	letter := strings.ToUpper(s)
+	if _, ok := form8949ShortTermBoxes[letter]; !ok {
+		return fmt.Errorf("Invalid Form 8949 box (must be one of \"A\", \"B\" or \"C\"): %s", s)
+	}
+	*box = Form8949Box(letter)
+	return nil
+}
+
+// Letter of the box checked in Part I (short-term) or Part II (long-term)
+func (box Form8949Box) forTerm(term string) string {
+	if term == LongTerm {
+		return string(rune(box[0] + 3))
+	}
+	return string(box)
+}
+
+// Schedule D line that the totals of the given part are reported on
+func (box Form8949Box) scheduleDLine(term string) string {
+	letter := box.forTerm(term)
+	if term == LongTerm {
+		return form8949LongTermBoxes[letter]
+	}
+	return form8949ShortTermBoxes[letter]
+}
+
+// Adjustment code of rows with a loss disallowed by the wash sale rule
+const form8949WashSaleCode = "W"
+
+// A single row of Form 8949, with proceeds, cost basis and adjustment rounded to cents as they are entered on the form
+type form8949Row struct {
+	description string
+	acquired    string
+	sold        string
+	proceeds    Decimal
+	costBasis   Decimal
+	// Adjustment code (column f), and the amount of the adjustment to gain or loss (column g)
+	code       string
+	adjustment Decimal
+}
+
+// Realized gain of the row after adjustment; negative values are losses
+func (row form8949Row) Gain() Decimal {
+	return row.proceeds.Sub(row.costBasis).Add(row.adjustment)
+}
+
+// Function to round a Decimal to the cents shown on Form 8949 (totals must add up from the rounded rows)
+func roundToCents(d Decimal) Decimal {
+	cents, _ := ParseDecimal(d.StringFixed(2))
+	return cents
+}
+
+// Function to build the Form 8949 row describing disposal
+// The description names the asset symbol, or "units" if no symbol was given
+func newForm8949Row(disposal Disposal) form8949Row {
+	asset := disposal.Symbol
+	if asset == "" {
+		asset = "units"
+	}
+	row := form8949Row{
+		description: fmt.Sprintf("%s %s (lot %d)", disposal.Quantity.StringFixed(8), asset, disposal.LotID),
+		acquired:    disposal.Acquired.Format(form8949DateLayout),
+		sold:        disposal.Sold.Format(form8949DateLayout),
+		proceeds:    roundToCents(disposal.Proceeds),
+		costBasis:   roundToCents(disposal.CostBasis),
+	}
+	if disposal.DisallowedLoss.Sign() != 0 {
+		row.code = form8949WashSaleCode
+		row.adjustment = roundToCents(disposal.DisallowedLoss)
+	}
+	return row
+}
+
+// Adjustment amount of the row as entered on the form (left blank without an adjustment code)
+func (row form8949Row) adjustmentString() string {
+	if row.code == "" {
+		return ""
+	}
+	return row.adjustment.StringFixed(2)
+}
+
+// Function to write disposals to out as Form 8949 shaped CSV, split into Part I (short-term) and Part II (long-term)
+// Each part starts with a title line naming the checked box, followed by a header line, one row per disposal
+// (with adjustment code W and the disallowed loss for wash sales), and a totals line naming the Schedule D line the totals are carried to; parts without disposals are omitted
+func WriteForm8949(out io.Writer, disposals []Disposal, longTermThreshold HoldingPeriod, box Form8949Box) error {
+	parts := []struct {
+		title string
+		term  string
+	}{
+		{"Part I - Short-Term", ShortTerm},
+		{"Part II - Long-Term", LongTerm},
+	}
+
+	writer := csv.NewWriter(out)
+	wroteAPart := false
+	for _, part := range parts {
+		var rows []form8949Row
+		for _, disposal := range disposals {
+			if disposal.Term(longTermThreshold) == part.term {
+				rows = append(rows, newForm8949Row(disposal))
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		if wroteAPart {
+			writer.Write([]string{})
+		}
+		wroteAPart = true
+		writer.Write([]string{part.title, "Box " + box.forTerm(part.term)})
+		writer.Write([]string{"Description", "Date Acquired", "Date Sold", "Proceeds", "Cost Basis", "Code", "Adjustment", "Gain or Loss"})
+		var totals form8949Row
+		for _, row := range rows {
+			writer.Write([]string{row.description, row.acquired, row.sold, row.proceeds.StringFixed(2), row.costBasis.StringFixed(2), row.code, row.adjustmentString(), row.Gain().StringFixed(2)})
+			totals.proceeds = totals.proceeds.Add(row.proceeds)
+			totals.costBasis = totals.costBasis.Add(row.costBasis)
+			totals.adjustment = totals.adjustment.Add(row.adjustment)
+		}
+		totalsLabel := fmt.Sprintf("Totals (Schedule D line %s)", box.scheduleDLine(part.term))
+		writer.Write([]string{totalsLabel, "", "", totals.proceeds.StringFixed(2), totals.costBasis.StringFixed(2), "", totals.adjustment.StringFixed(2), totals.Gain().StringFixed(2)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,104 @@
+package taxlot
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteForm8949(t *testing.T) {
+	_, disposals, err := ProcessTransactions([]string{
+		"2020-01-01,buy,10000.00,1.00000000",
+		"2020-06-01,buy,20000.00,1.00000000",
+		"2021-01-02,sell,40000.00,0.75000000",
+		"2021-03-01,sell,10000.00,0.50000000",
+		"2021-03-02,sell,30000.00,0.00000001",
+	}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := WriteForm8949(&out, disposals, DefaultLongTermThreshold, DefaultForm8949Box); err != nil {
+		t.Fatalf("WriteForm8949: %s", err.Error())
+	}
+	want := "Part I - Short-Term,Box C\n" +
+		"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Code,Adjustment,Gain or Loss\n" +
+		"0.25000000 units (lot 2),06/01/2020,03/01/2021,2500.00,5000.00,,,-2500.00\n" +
+		"0.00000001 units (lot 2),06/01/2020,03/02/2021,0.00,0.00,,,0.00\n" +
+		"Totals (Schedule D line 3),,,2500.00,5000.00,,0.00,-2500.00\n" +
+		"\n" +
+		"Part II - Long-Term,Box F\n" +
+		"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Code,Adjustment,Gain or Loss\n" +
+		"0.75000000 units (lot 1),01/01/2020,01/02/2021,30000.00,7500.00,,,22500.00\n" +
+		"0.25000000 units (lot 1),01/01/2020,03/01/2021,2500.00,2500.00,,,0.00\n" +
+		"Totals (Schedule D line 10),,,32500.00,10000.00,,0.00,22500.00\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteForm8949: Expected output:\n%s\n... got:\n%s\ninstead", want, got)
+	}
+}
+
+func TestForm8949TotalsAddUpFromRoundedRows(t *testing.T) {
+	// Each row's proceeds are 0.015 before rounding (0.045 in total); the totals must add up the rounded amounts shown on each row
+	_, disposals, err := ProcessTransactions([]string{
+		"2021-01-01,buy,0.01,1.00000000",
+		"2021-01-02,buy,0.01,1.00000000",
+		"2021-01-03,buy,0.01,1.00000000",
+		"2021-02-01,sell,0.015,3.00000000",
+	}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := WriteForm8949(&out, disposals, DefaultLongTermThreshold, Form8949Box("A")); err != nil {
+		t.Fatalf("WriteForm8949: %s", err.Error())
+	}
+	want := "Part I - Short-Term,Box A\n" +
+		"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Code,Adjustment,Gain or Loss\n" +
+		"1.00000000 units (lot 1),01/01/2021,02/01/2021,0.02,0.01,,,0.01\n" +
+		"1.00000000 units (lot 2),01/02/2021,02/01/2021,0.02,0.01,,,0.01\n" +
+		"1.00000000 units (lot 3),01/03/2021,02/01/2021,0.02,0.01,,,0.01\n" +
+		"Totals (Schedule D line 1b),,,0.06,0.03,,0.00,0.03\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteForm8949: Expected output:\n%s\n... got:\n%s\ninstead", want, got)
+	}
+}
+
+func TestForm8949BoxFlag(t *testing.T) {
+	var box Form8949Box
+	if err := box.Set("b"); err != nil {
+		t.Fatalf("Form8949Box.Set: %s", err.Error())
+	}
+	if box.forTerm(ShortTerm) != "B" || box.forTerm(LongTerm) != "E" {
+		t.Errorf("Form8949Box: Expected boxes B and E ... got %s and %s instead", box.forTerm(ShortTerm), box.forTerm(LongTerm))
+	}
+	if box.scheduleDLine(ShortTerm) != "2" || box.scheduleDLine(LongTerm) != "9" {
+		t.Errorf("Form8949Box: Expected Schedule D lines 2 and 9 ... got %s and %s instead", box.scheduleDLine(ShortTerm), box.scheduleDLine(LongTerm))
+	}
+	if err := box.Set("D"); err == nil {
+		t.Errorf("Form8949Box.Set: Expected long-term box letter to be rejected")
+	}
+}
+
+func TestForm8949WashSaleAdjustment(t *testing.T) {
+	_, disposals, err := ProcessTransactionsWithOptions([]string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-02-01,sell,50.00,4.00000000",
+		"2021-02-15,buy,60.00,1.00000000",
+	}, "fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := WriteForm8949(&out, disposals, DefaultLongTermThreshold, DefaultForm8949Box); err != nil {
+		t.Fatalf("WriteForm8949: %s", err.Error())
+	}
+	want := "Part I - Short-Term,Box C\n" +
+		"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Code,Adjustment,Gain or Loss\n" +
+		"4.00000000 units (lot 1),01/01/2021,02/01/2021,200.00,400.00,W,50.00,-150.00\n" +
+		"Totals (Schedule D line 3),,,200.00,400.00,,50.00,-150.00\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteForm8949: Expected output:\n%s\n... got:\n%s\ninstead", want, got)
+	}
+}
@@ -0,0 +1,162 @@
+package taxlot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// A HarvestSale is a proposed sale of (part of) a remaining lot at its market price
+type HarvestSale struct {
+	Lot      Lot
+	Date     time.Time
+	Quantity Decimal
+	// Cost basis of the quantity sold (including buy fees) and its proceeds, at the market price
+	CostBasis Decimal
+	Proceeds  Decimal
+	// Holding period classification (ShortTerm or LongTerm) as of the date of the sale
+	Term string
+}
+
+// Gain the sale would realize; negative values are losses
+func (sale HarvestSale) Gain() Decimal {
+	return sale.Proceeds.Sub(sale.CostBasis)
+}
+
+// Sales are formatted like disposals, as lotId,acquiredDate,soldDate,quantity,costBasis,proceeds,gain
+func (sale HarvestSale) String() string {
+	return fmt.Sprintf("%d,%s,%s,%s,%s,%s,%s", sale.Lot.ID, sale.Lot.Date.Format(DateLayout), sale.Date.Format(DateLayout), sale.Quantity.StringFixed(8), sale.CostBasis.StringFixed(2), sale.Proceeds.StringFixed(2), sale.Gain().StringFixed(2))
+}
+
+// A HarvestPlan is a set of proposed sales realizing (up to) a target gain or loss
+type HarvestPlan struct {
+	Date  time.Time
+	Sales []HarvestSale
+	// Assets with losses left unharvested, since lots of them bought within 30 days before the date would make the sales
+	// wash sales
+	WashSaleRisks []string
+}
+
+// Total gain the proposed sales would realize; negative values are losses
+func (plan HarvestPlan) Gain() Decimal {
+	var total Decimal
+	for _, sale := range plan.Sales {
+		total = total.Add(sale.Gain())
+	}
+	return total
+}
+
+// Function to propose sales of the remaining lots at their market price as of date (see MarketPrice) that realize a total
+// of target without exceeding it: a negative target is a loss to harvest, and a positive target is the most gain to realize
+// Lots with the largest gain (or loss) per unit are sold first, so that as few units as possible are sold, and only as much
+// of the last lot is sold as is needed to reach the target. Losses are not harvested from assets bought within the 30 days
+// before date, since those buys would disallow the losses: buys are looked up in transactions (the transactions the lots
+// remain from, which include buys pooled into earlier lots or sold off since) as well as in the dates of the lots (other
+// than wash sale replacement lots), which cover opening lots
+// Returns a plan realizing less than the target if the lots do not hold enough gains (or losses)
+func PlanHarvest(lots []Lot, transactions []Transaction, quotes []Quote, date time.Time, target Decimal, longTermThreshold HoldingPeriod) (HarvestPlan, error) {
+	if target.Sign() == 0 {
+		return HarvestPlan{}, fmt.Errorf("Invalid harvest target (must not be zero)")
+	}
+	gains, err := ValueLots(lots, quotes, date, longTermThreshold)
+	if err != nil {
+		return HarvestPlan{}, err
+	}
+	plan := HarvestPlan{Date: date}
+
+	// Assets bought within the wash sale window before date
+	windowStart := date.AddDate(0, 0, -washSaleDays)
+	recentlyBought := map[string]bool{}
+	for _, lot := range lots {
+		if !lot.Date.Before(windowStart) && !lot.washReplacement {
+			recentlyBought[lot.Symbol] = true
+		}
+	}
+	for _, tx := range transactions {
+		if tx.Type == Buy && !tx.Date.Before(windowStart) && !tx.Date.After(date) {
+			recentlyBought[tx.Symbol] = true
+		}
+	}
+
+	var candidates []UnrealizedGain
+	for _, gain := range gains {
+		unitGain := gain.MarketPrice.Sub(gain.Lot.Price)
+		if target.Sign() < 0 && unitGain.Sign() < 0 && recentlyBought[gain.Lot.Symbol] {
+			if len(plan.WashSaleRisks) == 0 || plan.WashSaleRisks[len(plan.WashSaleRisks)-1] != gain.Lot.Symbol {
+				plan.WashSaleRisks = append(plan.WashSaleRisks, gain.Lot.Symbol)
+			}
+			continue
+		}
+		if (target.Sign() < 0 && unitGain.Sign() < 0) || (target.Sign() > 0 && unitGain.Sign() > 0) {
+			candidates = append(candidates, gain)
+		}
+	}
+	// Sorting by the absolute gain per unit in descending order; lots are grouped by asset, so ties keep that order
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iGain, jGain := candidates[i].MarketPrice.Sub(candidates[i].Lot.Price), candidates[j].MarketPrice.Sub(candidates[j].Lot.Price)
+		if target.Sign() < 0 {
+			return iGain.Cmp(jGain) < 0
+		}
+		return iGain.Cmp(jGain) > 0
+	})
+
+	remaining := target
+	for _, candidate := range candidates {
+		if remaining.Sign() == 0 {
+			break
+		}
+		sale := newHarvestSale(candidate, candidate.Lot.Quantity, date, longTermThreshold)
+		if (target.Sign() < 0 && sale.Gain().Cmp(remaining) < 0) || (target.Sign() > 0 && sale.Gain().Cmp(remaining) > 0) {
+			// Only part of the lot is needed: sell the most units that do not exceed the remaining target
+			quantity := remaining.Div(candidate.MarketPrice.Sub(candidate.Lot.Price))
+			sale = newHarvestSale(candidate, quantity, date, longTermThreshold)
+			for quantity.Sign() > 0 && ((target.Sign() < 0 && sale.Gain().Cmp(remaining) < 0) || (target.Sign() > 0 && sale.Gain().Cmp(remaining) > 0)) {
+				quantity = quantity.Sub(decimalUnit)
+				sale = newHarvestSale(candidate, quantity, date, longTermThreshold)
+			}
+			if quantity.Sign() == 0 {
+				continue
+			}
+		}
+		plan.Sales = append(plan.Sales, sale)
+		remaining = remaining.Sub(sale.Gain())
+	}
+	return plan, nil
+}
+
+// Function to build the proposed sale of quantity units of the lot valued by gain
+func newHarvestSale(gain UnrealizedGain, quantity Decimal, date time.Time, longTermThreshold HoldingPeriod) HarvestSale {
+	return HarvestSale{
+		Lot:       gain.Lot,
+		Date:      date,
+		Quantity:  quantity,
+		CostBasis: gain.Lot.Price.Mul(quantity),
+		Proceeds:  gain.MarketPrice.Mul(quantity),
+		Term:      gain.Term,
+	}
+}
+
+// Function to write a harvest plan to out, one proposed sale per line in the format of
+// lotId,acquiredDate,soldDate,quantity,costBasis,proceeds,gain,term,symbol,account
+// followed by a total,gain line with the total realized by the plan, and a comment line for every asset whose losses were
+// left unharvested to avoid wash sales
+func WriteHarvestPlan(out io.Writer, plan HarvestPlan) error {
+	for _, sale := range plan.Sales {
+		if _, err := fmt.Fprintf(out, "%s,%s,%s,%s\n", sale.String(), sale.Term, sale.Lot.Symbol, sale.Lot.Account); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(out, "total,%s\n", plan.Gain().StringFixed(2)); err != nil {
+		return err
+	}
+	for _, symbol := range plan.WashSaleRisks {
+		if symbol == "" {
+			symbol = "(no symbol)"
+		}
+		if _, err := fmt.Fprintf(out, "# %s losses not harvested: lots bought within %d days before %s would make the sales wash sales\n", symbol, washSaleDays, plan.Date.Format(DateLayout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
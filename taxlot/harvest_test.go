@@ -0,0 +1,101 @@
+package taxlot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPlanHarvest(t *testing.T) {
+	lots, _, err := ProcessTransactionsWithOptions([]string{
+		"2020-01-01,buy,100.00,10.00000000",
+		"2021-01-01,buy,150.00,4.00000000",
+		"2021-05-01,buy,90.00,2.00000000,ETH",
+		"2021-06-20,buy,50.00,1.00000000,ETH",
+		"2021-06-21,buy,200.00,1.00000000,SOL",
+	}, "fifo", Options{})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+	quotes := []Quote{{Date: mustParseDate("2021-07-01"), Price: DecimalFromInt(120)}}
+
+	testCases := []struct {
+		target   Decimal
+		date     string
+		expected string
+	}{
+		// SOL was bought within 30 days, so its loss is left alone; the other loss falls short of the target
+		{DecimalFromInt(-150), "2021-07-01", "2,2021-01-01,2021-07-01,4.00000000,600.00,480.00,-120.00,short,,\n" +
+			"total,-120.00\n" +
+			"# SOL losses not harvested: lots bought within 30 days before 2021-07-01 would make the sales wash sales\n"},
+		// Once the wash sale window has passed, the largest loss per unit is harvested first, and only part of the next lot
+		{DecimalFromInt(-100), "2021-08-01", "1,2021-06-21,2021-08-01,1.00000000,200.00,120.00,-80.00,short,SOL,\n" +
+			"2,2021-01-01,2021-08-01,0.66666666,100.00,80.00,-20.00,short,,\n" +
+			"total,-100.00\n"},
+		// Gains never exceed the target
+		{DecimalFromInt(150), "2021-07-01", "2,2021-06-20,2021-07-01,1.00000000,50.00,120.00,70.00,short,ETH,\n" +
+			"1,2021-05-01,2021-07-01,2.00000000,180.00,240.00,60.00,short,ETH,\n" +
+			"1,2020-01-01,2021-07-01,1.00000000,100.00,120.00,20.00,long,,\n" +
+			"total,150.00\n"},
+		{mustParseDecimal("0.00000001"), "2021-07-01", "total,0.00\n"},
+	}
+	for _, testCase := range testCases {
+		plan, err := PlanHarvest(lots, nil, quotes, mustParseDate(testCase.date), testCase.target, DefaultLongTermThreshold)
+		if err != nil {
+			t.Fatalf("PlanHarvest (%s): %s", testCase.target, err.Error())
+		}
+		if (testCase.target.Sign() < 0 && plan.Gain().Cmp(testCase.target) < 0) || (testCase.target.Sign() > 0 && plan.Gain().Cmp(testCase.target) > 0) {
+			t.Errorf("PlanHarvest (%s): Expected the plan not to exceed the target ... got %s instead", testCase.target, plan.Gain())
+		}
+		var out bytes.Buffer
+		if err := WriteHarvestPlan(&out, plan); err != nil {
+			t.Fatalf("WriteHarvestPlan: %s", err.Error())
+		}
+		if out.String() != testCase.expected {
+			t.Errorf("WriteHarvestPlan (%s): Expected:\n%s... got:\n%s instead", testCase.target, testCase.expected, out.String())
+		}
+	}
+
+	if _, err := PlanHarvest(lots, nil, quotes, mustParseDate("2021-07-01"), Decimal{}, DefaultLongTermThreshold); err == nil || !strings.Contains(err.Error(), "Invalid harvest target") {
+		t.Errorf("PlanHarvest: Expected an invalid target error ... got %v instead", err)
+	}
+}
+
+func TestPlanHarvestLooksBackAtEveryBuy(t *testing.T) {
+	testCases := []struct {
+		algorithm    string
+		transactions []string
+	}{
+		// The pool keeps the date of its first buy, so the recent buy only shows in the transactions
+		{"average", []string{"2021-01-01,buy,100.00,1.00000000", "2021-06-25,buy,60.00,1.00000000"}},
+		// The recent buy was sold off again
+		{"lifo", []string{"2021-01-01,buy,100.00,1.00000000", "2021-06-25,buy,60.00,1.00000000", "2021-06-26,sell,55.00,1.00000000"}},
+	}
+	quotes := []Quote{{Date: mustParseDate("2021-06-01"), Price: DecimalFromInt(50)}}
+	for _, testCase := range testCases {
+		transactions, err := ParseTransactionLog(testCase.transactions)
+		if err != nil {
+			t.Fatalf("ParseTransactionLog: %s", err.Error())
+		}
+		lots, _, err := Process(transactions, testCase.algorithm, Options{})
+		if err != nil {
+			t.Fatalf("Process (%s): %s", testCase.algorithm, err.Error())
+		}
+		plan, err := PlanHarvest(lots, transactions, quotes, mustParseDate("2021-07-01"), DecimalFromInt(-50), DefaultLongTermThreshold)
+		if err != nil {
+			t.Fatalf("PlanHarvest (%s): %s", testCase.algorithm, err.Error())
+		}
+		if len(plan.Sales) != 0 || len(plan.WashSaleRisks) != 1 {
+			t.Errorf("PlanHarvest (%s): Expected the loss to be left unharvested as a wash sale risk ... got %+v instead", testCase.algorithm, plan)
+		}
+
+		// Buys after the date do not count
+		plan, err = PlanHarvest(lots, transactions, quotes, mustParseDate("2021-06-24"), DecimalFromInt(-50), DefaultLongTermThreshold)
+		if err != nil {
+			t.Fatalf("PlanHarvest (%s): %s", testCase.algorithm, err.Error())
+		}
+		if len(plan.Sales) == 0 || len(plan.WashSaleRisks) != 0 {
+			t.Errorf("PlanHarvest (%s): Expected the loss to be harvested before the recent buy ... got %+v instead", testCase.algorithm, plan)
+		}
+	}
+}
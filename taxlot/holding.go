@@ -0,0 +1,89 @@
+package taxlot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of transaction dates (ISO-8601 calendar dates, e.g. 2021-01-31)
+const DateLayout = "2006-01-02"
+
+// Holding period classifications of a disposal
+const (
+	ShortTerm = "short"
+	LongTerm  = "long"
+)
+
+// A HoldingPeriod is a calendar length of time; disposals of lots held for longer than the long-term threshold are long-term
+type HoldingPeriod struct {
+	Years  int
+	Months int
+	Days   int
+}
+
+// US rules: assets held for more than one year are long-term
+var DefaultLongTermThreshold = HoldingPeriod{Years: 1}
+
+// Accepted holding period syntax: any combination of years, months and days, in that order (e.g. "1y", "18m", "1y6m", "365d")
+var holdingPeriodPattern = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)m)?(?:(\d+)d)?$`)
+
+// Function to parse a holding period such as "1y" or "1y6m"
+func ParseHoldingPeriod(s string) (HoldingPeriod, error) {
+	matches := holdingPeriodPattern.FindStringSubmatch(strings.ToLower(s))
+	if s == "" || matches == nil {
+		return HoldingPeriod{}, fmt.Errorf("Invalid holding period (must be a combination of years, months and days, e.g. \"1y\", \"18m\" or \"365d\"): %s", s)
+	}
+	var period HoldingPeriod
+	for idx, field := range []*int{&period.Years, &period.Months, &period.Days} {
+		if matches[idx+1] != "" {
+			*field, _ = strconv.Atoi(matches[idx+1])
+		}
+	}
+	return period, nil
+}
+
+func (period HoldingPeriod) String() string {
+	var parts []string
+	if period.Years != 0 {
+		parts = append(parts, fmt.Sprintf("%dy", period.Years))
+	}
+	if period.Months != 0 {
+		parts = append(parts, fmt.Sprintf("%dm", period.Months))
+	}
+	if period.Days != 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%dd", period.Days))
+	}
+	return strings.Join(parts, "")
+}
+
+// Set implements flag.Value, so that a HoldingPeriod can be passed in on the command-line
+func (period *HoldingPeriod) Set(s string) error {
+	parsed, err := ParseHoldingPeriod(s)
+	if err != nil {
+		return err
+	}
+	*period = parsed
+	return nil
+}
+
+// Function to classify the holding period of an asset acquired and disposed of on the given dates, as either ShortTerm or LongTerm
+// The asset must be held for strictly longer than the threshold to be long-term
+func (period HoldingPeriod) Classify(acquired time.Time, disposed time.Time) string {
+	if disposed.After(acquired.AddDate(period.Years, period.Months, period.Days)) {
+		return LongTerm
+	}
+	return ShortTerm
+}
+
+// Function to parse a transaction date in DateLayout format
+// Dates must be valid calendar dates with exactly four year digits and two month and day digits, without a time or zone
+func ParseDate(s string) (time.Time, error) {
+	date, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date (must be in YYYY-MM-DD format): %s", s)
+	}
+	return date, nil
+}
@@ -0,0 +1,76 @@
+package taxlot
+
+import (
+	"testing"
+	"time"
+)
+
+// Helper function to parse a date literal in tests, panicking on malformed input
+func mustParseDate(s string) time.Time {
+	date, err := ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
+
+func TestParseHoldingPeriod(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected HoldingPeriod
+	}{
+		{"1y", HoldingPeriod{Years: 1}},
+		{"18m", HoldingPeriod{Months: 18}},
+		{"365d", HoldingPeriod{Days: 365}},
+		{"1y6m", HoldingPeriod{Years: 1, Months: 6}},
+		{"2Y1M3D", HoldingPeriod{Years: 2, Months: 1, Days: 3}},
+		{"0d", HoldingPeriod{}},
+	}
+	for _, testCase := range testCases {
+		result, err := ParseHoldingPeriod(testCase.input)
+		if err != nil {
+			t.Errorf("ParseHoldingPeriod(%q): Unexpected error: %s", testCase.input, err.Error())
+			continue
+		}
+		if result != testCase.expected {
+			t.Errorf("ParseHoldingPeriod(%q): Expected %s ... got %s instead", testCase.input, testCase.expected, result)
+		}
+	}
+
+	for _, badInput := range []string{"", "1", "y", "1w", "6m1y", "-1y", "1.5y"} {
+		if _, err := ParseHoldingPeriod(badInput); err == nil {
+			t.Errorf("ParseHoldingPeriod(%q): Expected an error, but none resulted", badInput)
+		}
+	}
+}
+
+func TestHoldingPeriodClassify(t *testing.T) {
+	testCases := []struct {
+		threshold HoldingPeriod
+		Acquired  string
+		disposed  string
+		expected  string
+	}{
+		{DefaultLongTermThreshold, "2021-01-01", "2021-12-31", ShortTerm},
+		{DefaultLongTermThreshold, "2021-01-01", "2022-01-01", ShortTerm},
+		{DefaultLongTermThreshold, "2021-01-01", "2022-01-02", LongTerm},
+		{DefaultLongTermThreshold, "2020-02-28", "2021-03-01", LongTerm},
+		{HoldingPeriod{Months: 6}, "2021-01-31", "2021-08-01", LongTerm},
+		{HoldingPeriod{Days: 30}, "2021-01-01", "2021-01-31", ShortTerm},
+		{HoldingPeriod{}, "2021-01-01", "2021-01-01", ShortTerm},
+		{HoldingPeriod{}, "2021-01-01", "2021-01-02", LongTerm},
+	}
+	for _, testCase := range testCases {
+		if result := testCase.threshold.Classify(mustParseDate(testCase.Acquired), mustParseDate(testCase.disposed)); result != testCase.expected {
+			t.Errorf("classify(%s, %s) with threshold %s: Expected %s ... got %s instead", testCase.Acquired, testCase.disposed, testCase.threshold, testCase.expected, result)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for _, badInput := range []string{"", "2021-1-1", "01/01/2021", "2021-02-30", "2021-01-01T00:00:00Z", "yesterday"} {
+		if _, err := ParseDate(badInput); err == nil {
+			t.Errorf("ParseDate(%q): Expected an error, but none resulted", badInput)
+		}
+	}
+}
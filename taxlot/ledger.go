@@ -0,0 +1,468 @@
+// Package taxlot tracks tax lots: it applies buys and sales of assets to books of open lots, selecting the lots each
+// sale consumes with a registered algorithm (see LotSelector), and reports the gains realized by every sale
+package taxlot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Options changing how transactions are processed
+type Options struct {
+	// Detect wash sales, disallowing losses on sales of an asset bought again within 30 days before or after the sale
+	WashSales bool
+	// Lots held before the first transaction (e.g. the lots remaining after processing the previous tax year), which keep
+	// their ids, dates and prices; lots bought later are numbered from their NextID, or after the highest opening lot id of
+	// their asset and account if it is not given
+	// Opening lots cannot be combined with wash sale detection, as the losses realized before them are not carried forward
+	OpeningLots []Lot
+	// Accept buys at a price of zero, such as gifts received; otherwise a zero price is rejected as a likely mistake
+	AllowZeroPriceBuys bool
+}
+
+// A Ledger processes transactions one at a time, in chronological order, keeping a Book of open lots for every asset in
+// every account, along with the disposals realized by every sale
+type Ledger struct {
+	algorithm string
+	selector  LotSelector
+	options   Options
+	// Books of every account, keyed by account and then by asset symbol
+	accounts map[string]map[string]*Book
+	// Disposals not yet taken from the ledger, in the order of their sales; wash sale detection updates them in place
+	disposals []*Disposal
+	// Losses realized on every asset (keyed by symbol) that may still be disallowed by a later buy in any account (only
+	// when detecting wash sales)
+	pendingLosses map[string][]pendingWashLoss
+	previousDate  time.Time
+}
+
+// Function to create an empty Ledger, selecting lots with the named algorithm (see LookupLotSelector)
+// The uk algorithm looks ahead in the transaction log, so it is only available through Process and ProcessTransactions
+func NewLedger(algorithm string, options Options) (*Ledger, error) {
+	selector, err := LookupLotSelector(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := selector.(ukSelector); ok {
+		return nil, fmt.Errorf("The %s algorithm looks ahead in the transaction log, so it cannot process transactions one at a time", algorithm)
+	}
+	if err := checkOptions(algorithm, selector, options); err != nil {
+		return nil, err
+	}
+	ledger := &Ledger{algorithm: algorithm, selector: selector, options: options, accounts: map[string]map[string]*Book{}, pendingLosses: map[string][]pendingWashLoss{}}
+	for _, lot := range options.OpeningLots {
+		if err := ledger.book(lot.Account, lot.Symbol).Open(lot, selector); err != nil {
+			return nil, err
+		}
+	}
+	return ledger, nil
+}
+
+// Function to find the book of the asset with the given symbol in the given account, creating an empty book for assets
+// not seen before in the account
+func (ledger *Ledger) book(account string, symbol string) *Book {
+	books, ok := ledger.accounts[account]
+	if !ok {
+		books = map[string]*Book{}
+		ledger.accounts[account] = books
+	}
+	book, ok := books[symbol]
+	if !ok {
+		book = &Book{}
+		books[symbol] = book
+	}
+	return book
+}
+
+// Function to list the names of every account seen so far, in alphabetical order (the unnamed account first)
+func (ledger *Ledger) accountNames() []string {
+	names := make([]string, 0, len(ledger.accounts))
+	for name := range ledger.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function to make sure that the quantity and price of tx are positive, unless it is a zero-price buy allowed by the options
+// (splits instead need a positive ratio, and a price of zero when they pay no cash in lieu, and the price of transfers is
+// unused, so it may be zero)
+// Transactions parsed by ParseTransaction only need their price checked, but typed transactions may come from anywhere
+func checkTransaction(tx Transaction, options Options) error {
+	if tx.Type == Transfer {
+		if tx.Quantity.Sign() <= 0 {
+			return fmt.Errorf("Invalid quantity (must be positive): %s", tx.Quantity)
+		}
+		if tx.Price.Sign() < 0 {
+			return fmt.Errorf("Invalid price (must not be negative): %s", tx.Price)
+		}
+		if tx.ToAccount == tx.Account {
+			return fmt.Errorf("Invalid transfer (must name a different account to transfer to): %s", tx.ToAccount)
+		}
+		return nil
+	}
+	if tx.Type == Split {
+		if tx.SplitRatio.After.Sign() <= 0 || tx.SplitRatio.Before.Sign() <= 0 {
+			return fmt.Errorf("Invalid split ratio (must be positive): %s", tx.SplitRatio)
+		}
+		if tx.Price.Sign() < 0 {
+			return fmt.Errorf("Invalid cash in lieu price (must not be negative): %s", tx.Price)
+		}
+		return nil
+	}
+	if tx.Quantity.Sign() <= 0 {
+		return fmt.Errorf("Invalid quantity (must be positive): %s", tx.Quantity)
+	}
+	if tx.Price.Sign() < 0 || (tx.Price.Sign() == 0 && !(tx.Type == Buy && options.AllowZeroPriceBuys)) {
+		if tx.Type == Buy {
+			return fmt.Errorf("Invalid price (must be positive, unless zero-price buys such as gifts are allowed with -allow-zero-price): %s", tx.Price)
+		}
+		return fmt.Errorf("Invalid price (must be positive): %s", tx.Price)
+	}
+	return nil
+}
+
+// Function to determine whether the named algorithm can process transactions one at a time, with a Ledger or ProcessStream
+func Streamable(algorithm string) bool {
+	selector, err := LookupLotSelector(algorithm)
+	if err != nil {
+		return false
+	}
+	_, ok := selector.(ukSelector)
+	return !ok
+}
+
+// Function to make sure that the options are supported by the chosen lot selection algorithm
+func checkOptions(algorithm string, selector LotSelector, options Options) error {
+	if options.WashSales {
+		// Pooled lots cannot keep the adjusted basis and holding period of wash sale replacements apart from other shares
+		switch selector.(type) {
+		case averageSelector, ukSelector:
+			return fmt.Errorf("Wash sale detection is not supported by the %s algorithm", algorithm)
+		}
+		// Losses still pending at the end of the run the opening lots come from could be disallowed by buys in this one,
+		// but they are not carried forward with the lots, so they would be missed
+		if len(options.OpeningLots) != 0 {
+			return fmt.Errorf("Wash sale detection is not supported with opening lots, as losses realized before them are not carried forward; process the earlier transactions along with these instead")
+		}
+	}
+	return nil
+}
+
+// Function to apply the next transaction to the ledger, which must not be dated before the previous transaction
+// Transfers move lots from one account's book of the asset to another's, without realizing any gain
+// Returns the disposals realized by the transaction (if it is a sale, or a split paying cash in lieu of fractional units)
+func (ledger *Ledger) Apply(tx Transaction) ([]Disposal, error) {
+	if err := checkTransaction(tx, ledger.options); err != nil {
+		return nil, err
+	}
+	// Out-of-order transactions would silently break lot ordering (e.g. fifo), so refuse to process them
+	if tx.Date.Before(ledger.previousDate) {
+		return nil, fmt.Errorf("Transaction dated %s is before the preceding transaction (%s); transactions must be in chronological order", tx.Date.Format(DateLayout), ledger.previousDate.Format(DateLayout))
+	}
+	ledger.previousDate = tx.Date
+
+	var realized []Disposal
+	switch tx.Type {
+	case Buy:
+		book := ledger.book(tx.Account, tx.Symbol)
+		book.Buy(tx, ledger.selector)
+		if ledger.options.WashSales {
+			ledger.washFollowingBuy(book)
+		}
+	case Sell:
+		// Let the chosen algorithm decide which lots are sold first, then execute the sale
+		book := ledger.book(tx.Account, tx.Symbol)
+		saleDisposals, err := book.Sell(tx, ledger.selector)
+		if err != nil {
+			return nil, fmt.Errorf("Problem executing sale (%s): %s", ledger.algorithm, err.Error())
+		}
+		realized = ledger.realize(saleDisposals)
+	case Split:
+		// Splits apply to the asset in every account; cash in lieu of fractional units is a sale of them, which the chosen
+		// algorithm decides the lots of as well. Every account is split on a copy of its book first, so that a split
+		// failing in one account leaves every book unchanged
+		var books []*Book
+		var splitBooks []Book
+		var splitDisposals [][]Disposal
+		for _, account := range ledger.accountNames() {
+			book, ok := ledger.accounts[account][tx.Symbol]
+			if !ok {
+				continue
+			}
+			splitBook, disposals, err := book.split(tx, ledger.selector)
+			if err != nil {
+				return nil, fmt.Errorf("Problem executing split (%s): %s", ledger.algorithm, err.Error())
+			}
+			books = append(books, book)
+			splitBooks = append(splitBooks, splitBook)
+			splitDisposals = append(splitDisposals, disposals)
+		}
+		for idx, book := range books {
+			*book = splitBooks[idx]
+		}
+		if ledger.options.WashSales {
+			ledger.washSplit(tx)
+		}
+		for _, disposals := range splitDisposals {
+			realized = append(realized, ledger.realize(disposals)...)
+		}
+	case Transfer:
+		// Let the chosen algorithm decide which lots are moved first, like a sale
+		lots, err := ledger.book(tx.Account, tx.Symbol).Withdraw(tx, ledger.selector)
+		if err != nil {
+			return nil, fmt.Errorf("Problem executing transfer (%s): %s", ledger.algorithm, err.Error())
+		}
+		for idx := range lots {
+			lots[idx].Account = tx.ToAccount
+		}
+		ledger.book(tx.ToAccount, tx.Symbol).Deposit(lots, ledger.selector)
+	default:
+		return nil, fmt.Errorf("Invalid order type (must be \"buy\", \"sell\", \"split\" or \"transfer\"): %s", tx.Type)
+	}
+	return realized, nil
+}
+
+// Function to record the disposals realized by a sale (detecting wash sales across every account, if enabled)
+// Returns a copy of the disposals, since the ledger's own may still be updated in place by wash sale detection
+func (ledger *Ledger) realize(disposals []Disposal) []Disposal {
+	if ledger.options.WashSales {
+		ledger.washPrecedingBuys(disposals)
+	}
+	for idx := range disposals {
+		ledger.disposals = append(ledger.disposals, &disposals[idx])
+	}
+	return append([]Disposal(nil), disposals...)
+}
+
+// Function to list the open lots of every asset, grouped by account and then by asset symbol (both in alphabetical order)
+func (ledger *Ledger) Lots() []Lot {
+	var lots []Lot
+	for _, account := range ledger.accountNames() {
+		lots = append(lots, collectLots(ledger.accounts[account])...)
+	}
+	return lots
+}
+
+// Function to list the disposals realized by every sale applied so far (other than those taken), in the order of the sales
+// With wash sale detection, the disallowed loss of a disposal may still grow until 30 days after its sale
+func (ledger *Ledger) Disposals() []Disposal {
+	disposals := make([]Disposal, len(ledger.disposals))
+	for idx, disposal := range ledger.disposals {
+		disposals[idx] = *disposal
+	}
+	return disposals
+}
+
+// Function to remove and return the disposals that later transactions can no longer change, in the order of the sales
+// Without wash sale detection every disposal is settled as soon as it is realized; with it, a disposal is settled once
+// the ledger has moved more than 30 days past its sale. Taking disposals as they settle (which also drops the pending
+// wash sale losses no later buy can match) keeps the ledger from growing with the number of sales
+func (ledger *Ledger) TakeSettledDisposals() []Disposal {
+	settled := len(ledger.disposals)
+	if ledger.options.WashSales {
+		settled = 0
+		for settled < len(ledger.disposals) && ledger.disposals[settled].Sold.AddDate(0, 0, washSaleDays).Before(ledger.previousDate) {
+			settled++
+		}
+		ledger.dropExpiredLosses(ledger.previousDate)
+	}
+	return ledger.takeDisposals(settled)
+}
+
+// Function to remove and return every disposal realized so far, whether settled or not, in the order of the sales
+func (ledger *Ledger) TakeDisposals() []Disposal {
+	return ledger.takeDisposals(len(ledger.disposals))
+}
+
+// Function to remove and return the first count disposals of the ledger
+func (ledger *Ledger) takeDisposals(count int) []Disposal {
+	if count == 0 {
+		return nil
+	}
+	taken := make([]Disposal, count)
+	for idx := range taken {
+		taken[idx] = *ledger.disposals[idx]
+	}
+	remaining := copy(ledger.disposals, ledger.disposals[count:])
+	for idx := remaining; idx < len(ledger.disposals); idx++ {
+		ledger.disposals[idx] = nil
+	}
+	ledger.disposals = ledger.disposals[:remaining]
+	return taken
+}
+
+// Function to process all transactions in a transaction log
+// transactions must be an array of CSV strings representing the raw transaction details, in chronological order;
+// blank and comment lines (see TransactionScanner) are skipped, and errors name line numbers counting every entry
+// algorithm must be the name of a registered LotSelector (see LotSelectorNames)
+// Every asset in every account has its own independent Book, and the algorithm is applied to each of them separately
+// (the uk algorithm instead matches sales against acquisitions across the whole log; see matchUKDisposals)
+// Returns remaining lots after processing is complete (grouped by account and then by asset symbol, both in
+// alphabetical order), along with the disposals realized by every sale
+func ProcessTransactions(transactions []string, algorithm string) (lots []Lot, disposals []Disposal, err error) {
+	return ProcessTransactionsWithOptions(transactions, algorithm, Options{})
+}
+
+// Function to process all transactions in a transaction log (see ProcessTransactions) with the given options
+func ProcessTransactionsWithOptions(transactions []string, algorithm string, options Options) (lots []Lot, disposals []Disposal, err error) {
+	// First check to ensure algorithm is valid
+	if _, err := LookupLotSelector(algorithm); err != nil {
+		return nil, nil, err
+	}
+
+	// Parse every transaction up front, so that algorithms which look ahead in the log (uk) can see all of it
+	parsed, err := ParseTransactionLog(transactions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Process(parsed, algorithm, options)
+}
+
+// Function to parse every raw transaction in a transaction log (see ProcessTransactions), skipping blank and comment lines
+// Returns an error naming the line of the first transaction that cannot be parsed or is out of chronological order
+func ParseTransactionLog(transactions []string) ([]Transaction, error) {
+	parsed := make([]Transaction, 0, len(transactions))
+	var previousDate time.Time
+	for idx, rawTx := range transactions {
+		if isSkippedLine(rawTx) {
+			continue
+		}
+		tx, err := parseLogTransaction(rawTx, idx+1, previousDate)
+		if err != nil {
+			return nil, err
+		}
+		previousDate = tx.Date
+		parsed = append(parsed, tx)
+	}
+	return parsed, nil
+}
+
+// Function to process a transaction log read from in (see TransactionScanner), without holding the log in memory
+// Every transaction is parsed and applied as soon as it is read, and every disposal is passed to handle as soon as it is
+// settled (see Ledger.TakeSettledDisposals), in the order of the sales; an error returned by handle stops processing
+// The uk algorithm looks ahead in the transaction log, so it cannot be streamed (see Streamable)
+// Returns remaining lots after processing is complete (grouped by account and then by asset symbol, both in
+// alphabetical order)
+func ProcessStream(in io.Reader, algorithm string, options Options, handle func(Disposal) error) ([]Lot, error) {
+	ledger, err := NewLedger(algorithm, options)
+	if err != nil {
+		return nil, err
+	}
+	emit := func(disposals []Disposal) error {
+		for _, disposal := range disposals {
+			if err := handle(disposal); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	scanner := NewTransactionScanner(in)
+	for scanner.Scan() {
+		tx, err := parseLogTransaction(scanner.Text(), scanner.Line(), ledger.previousDate)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ledger.Apply(tx); err != nil {
+			return nil, lineError(tx, err)
+		}
+		if err := emit(ledger.TakeSettledDisposals()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := emit(ledger.TakeDisposals()); err != nil {
+		return nil, err
+	}
+	return ledger.Lots(), nil
+}
+
+// Function to parse the raw transaction on the given line of a transaction log, which must not be dated before previousDate
+func parseLogTransaction(rawTx string, line int, previousDate time.Time) (Transaction, error) {
+	tx, err := ParseTransaction(rawTx)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("Problem parsing raw transaction on line %d (%s): %s", line, rawTx, err.Error())
+	}
+	if tx.Date.Before(previousDate) {
+		return Transaction{}, fmt.Errorf("Transaction on line %d is dated %s, before the preceding transaction (%s); transactions must be in chronological order (or pass -sort to sort them)", line, tx.Date.Format(DateLayout), previousDate.Format(DateLayout))
+	}
+	tx.line = line
+	return tx, nil
+}
+
+// Function to name the line of the transaction log tx was read from in err, a problem processing tx (if tx was read from
+// a log, see ParseTransactionLog)
+func lineError(tx Transaction, err error) error {
+	if tx.line == 0 {
+		return err
+	}
+	return fmt.Errorf("Problem processing transaction on line %d: %s", tx.line, err.Error())
+}
+
+// Function to process typed transactions, which must be in chronological order, with the named algorithm
+// Returns remaining lots after processing is complete (grouped by account and then by asset symbol, both in
+// alphabetical order), along with the disposals realized by every sale
+func Process(transactions []Transaction, algorithm string, options Options) (lots []Lot, disposals []Disposal, err error) {
+	selector, err := LookupLotSelector(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := selector.(ukSelector); ok {
+		if err := checkOptions(algorithm, selector, options); err != nil {
+			return nil, nil, err
+		}
+		for _, lot := range options.OpeningLots {
+			if lot.Account != "" {
+				return nil, nil, fmt.Errorf("Accounts and transfers are not supported by the %s algorithm", algorithm)
+			}
+		}
+		for idx := range transactions {
+			if err := checkTransaction(transactions[idx], options); err != nil {
+				return nil, nil, lineError(transactions[idx], err)
+			}
+			if transactions[idx].Type == Split {
+				return nil, nil, lineError(transactions[idx], fmt.Errorf("Splits are not supported by the %s algorithm", algorithm))
+			}
+			if transactions[idx].Type == Transfer || transactions[idx].Account != "" {
+				return nil, nil, lineError(transactions[idx], fmt.Errorf("Accounts and transfers are not supported by the %s algorithm", algorithm))
+			}
+			if idx > 0 && transactions[idx].Date.Before(transactions[idx-1].Date) {
+				return nil, nil, lineError(transactions[idx], fmt.Errorf("Transaction dated %s is before the preceding transaction (%s); transactions must be in chronological order", transactions[idx].Date.Format(DateLayout), transactions[idx-1].Date.Format(DateLayout)))
+			}
+		}
+		lots, disposals, err = matchUKDisposals(transactions, options.OpeningLots)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lots, disposals, nil
+	}
+
+	ledger, err := NewLedger(algorithm, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tx := range transactions {
+		if _, err := ledger.Apply(tx); err != nil {
+			return nil, nil, lineError(tx, err)
+		}
+	}
+	return ledger.Lots(), ledger.TakeDisposals(), nil
+}
+
+// Function to gather the remaining lots of every book, grouped by asset symbol (in alphabetical order)
+func collectLots(books map[string]*Book) (lots []Lot) {
+	symbols := make([]string, 0, len(books))
+	for symbol := range books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		lots = append(lots, books[symbol].Lots()...)
+	}
+	return
+}
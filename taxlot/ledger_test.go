@@ -0,0 +1,1037 @@
+package taxlot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightedPrice(t *testing.T) {
+	smallLotAtTenThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(10000),
+		Quantity: DecimalFromInt(1),
+	}
+	smallLotAtFiftyThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(50000),
+		Quantity: DecimalFromInt(1),
+	}
+	mediumLotAtTenThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(10000),
+		Quantity: DecimalFromInt(10),
+	}
+	mediumLotAtFortyThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(40000),
+		Quantity: DecimalFromInt(10),
+	}
+	largeLotAtTenThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(10000),
+		Quantity: DecimalFromInt(100),
+	}
+	largeLotAtTwentyThousand := Lot{
+		ID:       1,
+		Date:     mustParseDate("2021-01-01"),
+		Price:    DecimalFromInt(20000),
+		Quantity: DecimalFromInt(100),
+	}
+
+	expectedWeightS10M10 := DecimalFromInt(10000)
+	if priceWeightResult := weightedPrice(smallLotAtTenThousand, mediumLotAtTenThousand); priceWeightResult.Cmp(expectedWeightS10M10) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightS10M10, priceWeightResult)
+	}
+
+	expectedWeightS10S50 := DecimalFromInt(30000)
+	if priceWeightResult := weightedPrice(smallLotAtTenThousand, smallLotAtFiftyThousand); priceWeightResult.Cmp(expectedWeightS10S50) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightS10S50, priceWeightResult)
+	}
+
+	expectedWeightS10M40 := mustParseDecimal("37272.72727273")
+	if priceWeightResult := weightedPrice(smallLotAtTenThousand, mediumLotAtFortyThousand); priceWeightResult.Cmp(expectedWeightS10M40) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightS10M40, priceWeightResult)
+	}
+
+	expectedWeightS10L10 := DecimalFromInt(10000)
+	if priceWeightResult := weightedPrice(smallLotAtTenThousand, largeLotAtTenThousand); priceWeightResult.Cmp(expectedWeightS10L10) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightS10L10, priceWeightResult)
+	}
+
+	expectedWeightM10L20 := mustParseDecimal("19090.90909091")
+	if priceWeightResult := weightedPrice(mediumLotAtTenThousand, largeLotAtTwentyThousand); priceWeightResult.Cmp(expectedWeightM10L20) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightM10L20, priceWeightResult)
+	}
+
+	expectedWeightL10L20 := DecimalFromInt(15000)
+	if priceWeightResult := weightedPrice(largeLotAtTenThousand, largeLotAtTwentyThousand); priceWeightResult.Cmp(expectedWeightL10L20) != 0 {
+		t.Errorf("Expected weighted price to be %s ... got %s instead", expectedWeightL10L20, priceWeightResult)
+	}
+
+}
+
+func TestParseRawTransaction(t *testing.T) {
+	resultingLot, err := ParseTransaction("2021-01-01,buy,10000.00,1.00000000")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	expectedLotResult := Transaction{
+		Date:     mustParseDate("2021-01-01"),
+		Type:     Buy,
+		Price:    DecimalFromInt(10000),
+		Quantity: DecimalFromInt(1),
+	}
+	deepEqualResult := reflect.DeepEqual(resultingLot, expectedLotResult)
+	if !deepEqualResult {
+		t.Errorf("ParseTransaction: Expected resultingLot to be %+v ... got %+v instead", expectedLotResult, resultingLot)
+	}
+
+	// Ids are assigned once the lot is added to a book
+	book := Book{}
+	book.Buy(resultingLot, fifoSelector{})
+	want := "1,2021-01-01,10000.00,1.00000000"
+	if got := book.Lots()[0].String(); got != want {
+		t.Errorf("ParseTransaction: Expected resultingLot.String() to be %s ... got %s instead", want, got)
+	}
+
+	symbolLot, err := ParseTransaction("2021-01-01,sell,10000.00,1.00000000,btc")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if symbolLot.Symbol != "BTC" || symbolLot.Type != Sell {
+		t.Errorf("ParseTransaction: Expected a sell of symbol BTC ... got a %s of symbol %s instead", symbolLot.Type, symbolLot.Symbol)
+	}
+}
+
+func TestSmallProcessTransactionsFIFO(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-02-01,sell,20000.00,0.50000000"}, "fifo")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(resultingLots) != 1 {
+		t.Errorf("ProcessTransactions: Expected 1 resulting lot back, got %d instead", len(resultingLots))
+	}
+	want := "1,2021-01-01,10000.00,0.50000000"
+	if got := resultingLots[0].String(); got != want {
+		t.Errorf("ProcessTransactions: Expected resultingLots[0].String() to be %s ... got %s instead", want, got)
+	}
+}
+
+func TestProcessTransactionsFIFO(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "fifo")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(resultingLots) != 1 {
+		t.Errorf("ProcessTransactions: Expected 1 resulting lot back, got %d instead", len(resultingLots))
+	}
+	want := "2,2021-01-02,20000.00,0.50000000"
+	if got := resultingLots[0].String(); got != want {
+		t.Errorf("ProcessTransactions: Expected resultingLots[0].String() to be %s ... got %s instead", want, got)
+	}
+}
+
+func TestProcessTransactionsHIFO(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "hifo")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(resultingLots) != 1 {
+		t.Errorf("ProcessTransactions: Expected 1 resulting lot back, got %d instead", len(resultingLots))
+	}
+	want := "1,2021-01-01,10000.00,0.50000000"
+	if got := resultingLots[0].String(); got != want {
+		t.Errorf("ProcessTransactions: Expected resultingLots[0].String() to be %s ... got %s instead", want, got)
+	}
+}
+
+func TestProcessTransactionsLIFO(t *testing.T) {
+	testCases := []struct {
+		name         string
+		transactions []string
+		expected     []string
+	}{
+		{
+			name:         "partial sale of most recent lot",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,0.50000000"},
+			expected:     []string{"1,2021-01-01,10000.00,1.00000000", "2,2021-01-02,20000.00,0.50000000"},
+		},
+		{
+			name:         "sale spanning multiple lots",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"},
+			expected:     []string{"1,2021-01-01,10000.00,0.50000000"},
+		},
+		{
+			name:         "most recent lot is not the highest priced",
+			transactions: []string{"2021-01-01,buy,20000.00,1.00000000", "2021-01-02,buy,10000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"},
+			expected:     []string{"1,2021-01-01,20000.00,0.50000000"},
+		},
+		{
+			name:         "same-date buys aggregated before sale",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,10000.00,1.00000000", "2021-01-02,buy,15000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"},
+			expected:     []string{"1,2021-01-01,10000.00,1.00000000", "2,2021-01-02,12500.00,0.50000000"},
+		},
+		{
+			name:         "buy after sale is consumed first by later sale",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,0.50000000", "2021-03-01,buy,30000.00,0.25000000", "2021-04-01,sell,30000.00,0.50000000"},
+			expected:     []string{"1,2021-01-01,10000.00,1.00000000", "2,2021-01-02,20000.00,0.25000000"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resultingLots, _, err := ProcessTransactions(testCase.transactions, "lifo")
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.name, err.Error())
+			continue
+		}
+		if len(resultingLots) != len(testCase.expected) {
+			t.Errorf("ProcessTransactions (%s): Expected %d resulting lot(s) back, got %d instead", testCase.name, len(testCase.expected), len(resultingLots))
+			continue
+		}
+		for idx, want := range testCase.expected {
+			if got := resultingLots[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected resultingLots[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+	}
+}
+
+func TestExcessiveSaleQuantityLIFO(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,5.00000000"}, "lifo")
+	if err == nil {
+		t.Fatalf("Sales exceeded buys, but no error resulted")
+	}
+	expectedErrorMessage := "Problem processing transaction on line 3: Problem executing sale (lifo): Sale quantity exceeded total buy quantity; please ensure that transaction log input is valid"
+	if err.Error() != expectedErrorMessage {
+		t.Errorf("Unexpected error resulted from excessive sales. Expected: \"%s\" ... got \"%s\" instead", expectedErrorMessage, err.Error())
+	}
+	if len(resultingLots) > 0 {
+		t.Errorf("Sales exceeded buys, but non-empty lot slice was returned")
+	}
+}
+
+func TestExcessiveSaleQuantity(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,5.00000000"}, "hifo")
+	if err == nil {
+		t.Errorf("Sales exceeded buys, but no error resulted")
+	}
+	expectedErrorMessage := "Problem processing transaction on line 3: Problem executing sale (hifo): Sale quantity exceeded total buy quantity; please ensure that transaction log input is valid"
+	if err.Error() != expectedErrorMessage {
+		t.Errorf("Unexpected error resulted from excessive sales. Expected: \"%s\" ... got \"%s\" instead", expectedErrorMessage, err.Error())
+	}
+	if len(resultingLots) > 0 {
+		t.Errorf("Sales exceeded buys, but non-empty lot slice was returned")
+	}
+}
+
+func TestFailedSalesLeaveBookUnchanged(t *testing.T) {
+	book := Book{}
+	for _, rawTx := range []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-01-03,buy,15000.00,1.00000000"} {
+		tx, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		book.Buy(tx, hifoSelector{})
+	}
+	want := book.Lots()
+
+	testCases := []struct {
+		selector LotSelector
+		rawTx    string
+	}{
+		{hifoSelector{}, "2021-02-01,sell,20000.00,5.00000000"},
+		{specIDSelector{fallback: fifoSelector{}}, "2021-02-01,sell,20000.00,2.00000000,,,,1;7"},
+		{specIDSelector{fallback: hifoSelector{}}, "2021-02-01,sell,20000.00,4.00000000,,,,3:0.5"},
+	}
+	for _, testCase := range testCases {
+		sale, err := ParseTransaction(testCase.rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := book.Sell(sale, testCase.selector); err == nil {
+			t.Errorf("Sell (%s): Expected an error, but none resulted", testCase.rawTx)
+		}
+		if got := book.Lots(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Sell (%s): Expected the book to be left unchanged as %v ... got %v instead", testCase.rawTx, want, got)
+		}
+	}
+}
+
+func TestBadAlgorithms(t *testing.T) {
+	firstLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "lol")
+	if err == nil {
+		t.Errorf("Erroneous algorithm didn't elicit an error")
+	}
+	expectedErrorSnippet := "Invalid algorithm (must be one of \"average\", \"fifo\", \"hifo\", \"lifo\", \"specid\" or \"uk\")"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from excessive sales. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(firstLots) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous algorithm")
+	}
+
+	secondLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "more than one word")
+	if err == nil {
+		t.Errorf("Erroneous algorithm didn't elicit an error (second attempt, multi-word)")
+	}
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from excessive sales. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(secondLots) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous algorithm")
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	extraFieldResult, _, err := ProcessTransactions([]string{"2021-01-01,extraneousField,buy,10000.00,1.00000000,BTC,1.00,USD,1,wallet,exchange", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,bad,20000.00,1.50000000"}, "fifo")
+	if err == nil {
+		t.Errorf("Extra nonsensical field didn't elicit an error")
+	}
+	expectedErrorSnippet := "Invalid tx format; incorrect argument count (should be between 4 and 10, got 11)"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from bad txType. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(extraFieldResult) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite presence of extra field")
+	}
+
+	badTxTypeResult, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,bad,20000.00,1.50000000"}, "fifo")
+	if err == nil {
+		t.Errorf("Erroneous txType didn't elicit an error")
+	}
+	expectedErrorSnippet = "Invalid order type (must be \"buy\", \"sell\", \"split\" or \"transfer\")"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from bad txType. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(badTxTypeResult) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous txType")
+	}
+
+	badPriceResult, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,200f00.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "fifo")
+	if err == nil {
+		t.Errorf("Erroneous price value didn't elicit an error")
+	}
+	expectedErrorSnippet = "Invalid (non-float) price"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from bad txType. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(badPriceResult) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous price value")
+	}
+
+	badQuantityResult, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.0000xyz0", "2021-02-01,sell,20000.00,1.50000000"}, "fifo")
+	if err == nil {
+		t.Errorf("Erroneous quantity value didn't elicit an error")
+	}
+	expectedErrorSnippet = "Invalid (non-float) quantity"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from bad txType. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(badQuantityResult) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous quantity value")
+	}
+
+	badDateResult, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "01/02/2021,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "fifo")
+	if err == nil {
+		t.Errorf("Erroneous date value didn't elicit an error")
+	}
+	expectedErrorSnippet = "Problem parsing raw transaction on line 2 (01/02/2021,buy,20000.00,1.00000000): Invalid date (must be in YYYY-MM-DD format)"
+	if !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Unexpected error resulted from bad date. Expected: \"%s\" ... got \"%s\" instead", expectedErrorSnippet, err.Error())
+	}
+	if len(badDateResult) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite erroneous date value")
+	}
+}
+
+func TestRealizedGains(t *testing.T) {
+	testCases := []struct {
+		name         string
+		transactions []string
+		algorithm    string
+		expected     []string
+	}{
+		{
+			name:         "partial sale of a single lot",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-02-01,sell,20000.00,0.50000000"},
+			algorithm:    "fifo",
+			expected:     []string{"1,2021-01-01,2021-02-01,0.50000000,5000.00,10000.00,5000.00"},
+		},
+		{
+			name:         "sale spanning two lots",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,15000.00,1.50000000"},
+			algorithm:    "fifo",
+			expected:     []string{"1,2021-01-01,2021-02-01,1.00000000,10000.00,15000.00,5000.00", "2,2021-01-02,2021-02-01,0.50000000,10000.00,7500.00,-2500.00"},
+		},
+		{
+			name:         "hifo consumes the highest-priced lot first",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,15000.00,1.50000000"},
+			algorithm:    "hifo",
+			expected:     []string{"2,2021-01-02,2021-02-01,1.00000000,20000.00,15000.00,-5000.00", "1,2021-01-01,2021-02-01,0.50000000,5000.00,7500.00,2500.00"},
+		},
+		{
+			name:         "multiple sales",
+			transactions: []string{"2021-01-01,buy,10000.00,1.00000000", "2021-02-01,sell,20000.00,0.25000000", "2021-03-01,sell,30000.00,0.75000000"},
+			algorithm:    "lifo",
+			expected:     []string{"1,2021-01-01,2021-02-01,0.25000000,2500.00,5000.00,2500.00", "1,2021-01-01,2021-03-01,0.75000000,7500.00,22500.00,15000.00"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, disposals, err := ProcessTransactions(testCase.transactions, testCase.algorithm)
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.name, err.Error())
+			continue
+		}
+		if len(disposals) != len(testCase.expected) {
+			t.Errorf("ProcessTransactions (%s): Expected %d disposal(s) back, got %d instead", testCase.name, len(testCase.expected), len(disposals))
+			continue
+		}
+		for idx, want := range testCase.expected {
+			if got := disposals[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected disposals[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+	}
+}
+
+func TestLotIdsAreNotReusedAfterSales(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,20000.00,2.00000000", "2021-03-01,buy,30000.00,1.00000000"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	want := "3,2021-03-01,30000.00,1.00000000"
+	if len(resultingLots) != 1 || resultingLots[0].String() != want {
+		t.Errorf("ProcessTransactions: Expected a single remaining lot %s ... got %v instead", want, resultingLots)
+	}
+}
+
+// Regression tests for quantities that are not exactly representable as binary floating point numbers
+func TestFloatEdgeCases(t *testing.T) {
+	testCases := []struct {
+		name         string
+		transactions []string
+		expected     []string
+	}{
+		{
+			name:         "selling 0.1 + 0.2 leaves no dust",
+			transactions: []string{"2021-01-01,buy,10000.00,0.1", "2021-01-02,buy,10000.00,0.2", "2021-02-01,sell,20000.00,0.3"},
+			expected:     []string{},
+		},
+		{
+			name:         "selling 0.3 as 0.1 then 0.2 does not exceed buys",
+			transactions: []string{"2021-01-01,buy,10000.00,0.3", "2021-02-01,sell,20000.00,0.1", "2021-02-02,sell,20000.00,0.2"},
+			expected:     []string{},
+		},
+		{
+			name:         "same-date buys of 0.1 and 0.2 sum exactly",
+			transactions: []string{"2021-01-01,buy,10000.00,0.1", "2021-01-01,buy,10000.00,0.2", "2021-02-01,sell,20000.00,0.29999999"},
+			expected:     []string{"1,2021-01-01,10000.00,0.00000001"},
+		},
+		{
+			name:         "many satoshi-sized sales",
+			transactions: []string{"2021-01-01,buy,10000.00,0.00000003", "2021-02-01,sell,20000.00,0.00000001", "2021-02-01,sell,20000.00,0.00000001", "2021-02-01,sell,20000.00,0.00000001"},
+			expected:     []string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resultingLots, _, err := ProcessTransactions(testCase.transactions, "fifo")
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.name, err.Error())
+			continue
+		}
+		if len(resultingLots) != len(testCase.expected) {
+			t.Errorf("ProcessTransactions (%s): Expected %d resulting lot(s) back, got %v instead", testCase.name, len(testCase.expected), resultingLots)
+			continue
+		}
+		for idx, want := range testCase.expected {
+			if got := resultingLots[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected resultingLots[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+	}
+}
+
+func TestProcessTransactionsMultiAsset(t *testing.T) {
+	transactions := []string{
+		"2021-01-01,buy,30000.00,1.00000000,BTC",
+		"2021-01-01,buy,1000.00,10.00000000,ETH",
+		"2021-01-02,buy,40000.00,1.00000000,BTC",
+		"2021-01-02,buy,2000.00,10.00000000,eth",
+		"2021-01-03,buy,100.00,2.00000000",
+		"2021-02-01,sell,50000.00,1.50000000,BTC",
+		"2021-02-01,sell,3000.00,5.00000000,ETH",
+	}
+	testCases := []struct {
+		algorithm string
+		expected  []string
+	}{
+		{"fifo", []string{"1,2021-01-03,100.00,2.00000000", "2,2021-01-02,40000.00,0.50000000,BTC", "1,2021-01-01,1000.00,5.00000000,ETH", "2,2021-01-02,2000.00,10.00000000,ETH"}},
+		{"hifo", []string{"1,2021-01-03,100.00,2.00000000", "1,2021-01-01,30000.00,0.50000000,BTC", "1,2021-01-01,1000.00,10.00000000,ETH", "2,2021-01-02,2000.00,5.00000000,ETH"}},
+	}
+	for _, testCase := range testCases {
+		resultingLots, _, err := ProcessTransactions(transactions, testCase.algorithm)
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.algorithm, err.Error())
+			continue
+		}
+		if len(resultingLots) != len(testCase.expected) {
+			t.Errorf("ProcessTransactions (%s): Expected %d resulting lots back, got %v instead", testCase.algorithm, len(testCase.expected), resultingLots)
+			continue
+		}
+		for idx, want := range testCase.expected {
+			if got := resultingLots[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected resultingLots[%d].String() to be %s ... got %s instead", testCase.algorithm, idx, want, got)
+			}
+		}
+	}
+
+	// Each asset's book is independent, so selling more of one asset than was bought is an error even if other assets are held
+	_, _, err := ProcessTransactions([]string{"2021-01-01,buy,30000.00,1.00000000,BTC", "2021-01-01,buy,1000.00,10.00000000,ETH", "2021-02-01,sell,3000.00,2.00000000,BTC"}, "fifo")
+	if err == nil {
+		t.Errorf("Sales of BTC exceeded buys of BTC, but no error resulted")
+	}
+}
+
+func TestTransactionFees(t *testing.T) {
+	testCases := []struct {
+		name              string
+		transactions      []string
+		expectedLots      []string
+		expectedDisposals []string
+	}{
+		{
+			name:              "buy fee increases basis",
+			transactions:      []string{"2021-01-01,buy,10000.00,2.00000000,BTC,20.00", "2021-02-01,sell,20000.00,0.50000000,BTC"},
+			expectedLots:      []string{"1,2021-01-01,10010.00,1.50000000,BTC"},
+			expectedDisposals: []string{"1,2021-01-01,2021-02-01,0.50000000,5005.00000000,10000.00000000,4995.00000000"},
+		},
+		{
+			name:              "buy fees affect same-date weighted price",
+			transactions:      []string{"2021-01-01,buy,10000.00,1.00000000,BTC,100.00", "2021-01-01,buy,20000.00,1.00000000,BTC,300.00"},
+			expectedLots:      []string{"1,2021-01-01,15200.00,2.00000000,BTC"},
+			expectedDisposals: []string{},
+		},
+		{
+			name:              "sell fee reduces proceeds, split pro rata across lots",
+			transactions:      []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,10000.00,2.00000000", "2021-02-01,sell,20000.00,3.00000000,,0.10"},
+			expectedLots:      []string{},
+			expectedDisposals: []string{"1,2021-01-01,2021-02-01,1.00000000,10000.00000000,19999.96666667,9999.96666667", "2,2021-01-02,2021-02-01,2.00000000,20000.00000000,39999.93333333,19999.93333333"},
+		},
+		{
+			name:              "fee paid in the asset is converted at the transaction price",
+			transactions:      []string{"2021-01-01,buy,10000.00,1.00000000,BTC,0.001,btc", "2021-02-01,sell,20000.00,1.00000000,BTC,0.0005,BTC"},
+			expectedLots:      []string{},
+			expectedDisposals: []string{"1,2021-01-01,2021-02-01,1.00000000,10010.00000000,19990.00000000,9980.00000000"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resultingLots, disposals, err := ProcessTransactions(testCase.transactions, "fifo")
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.name, err.Error())
+			continue
+		}
+		if len(resultingLots) != len(testCase.expectedLots) || len(disposals) != len(testCase.expectedDisposals) {
+			t.Errorf("ProcessTransactions (%s): Expected %d lot(s) and %d disposal(s) back, got %v and %v instead", testCase.name, len(testCase.expectedLots), len(testCase.expectedDisposals), resultingLots, disposals)
+			continue
+		}
+		for idx, want := range testCase.expectedLots {
+			if got := resultingLots[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected resultingLots[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+		for idx, want := range testCase.expectedDisposals {
+			disposal := disposals[idx]
+			got := fmt.Sprintf("%d,%s,%s,%s,%s,%s,%s", disposal.LotID, disposal.Acquired.Format(DateLayout), disposal.Sold.Format(DateLayout), disposal.Quantity, disposal.CostBasis, disposal.Proceeds, disposal.Gain())
+			if got != want {
+				t.Errorf("ProcessTransactions (%s): Expected disposals[%d] to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+	}
+
+	// Sale fees allocated across disposals must add back up to the original fee exactly
+	_, disposals, err := ProcessTransactions([]string{"2021-01-01,buy,1.00,1.00000000", "2021-01-02,buy,1.00,1.00000000", "2021-01-03,buy,1.00,1.00000000", "2021-02-01,sell,1.00,3.00000000,,0.00000001"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	var totalSaleFee Decimal
+	for _, disposal := range disposals {
+		totalSaleFee = totalSaleFee.Add(disposal.SaleFee)
+	}
+	if totalSaleFee.Cmp(decimalUnit) != 0 {
+		t.Errorf("Expected sale fees to add up to 0.00000001 ... got %s instead", totalSaleFee)
+	}
+
+	_, _, err = ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000,BTC,-1.00"}, "fifo")
+	if err == nil || !strings.Contains(err.Error(), "Invalid fee (must not be negative)") {
+		t.Errorf("Expected negative fee to be rejected ... got %v instead", err)
+	}
+}
+
+func TestOutOfOrderTransactions(t *testing.T) {
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-02,buy,20000.00,1.00000000", "2021-01-03,sell,20000.00,0.50000000", "2021-01-01,buy,10000.00,1.00000000"}, "fifo")
+	if err == nil {
+		t.Fatalf("Out-of-order transaction didn't elicit an error")
+	}
+	expectedErrorMessage := "Transaction on line 3 is dated 2021-01-01, before the preceding transaction (2021-01-03); transactions must be in chronological order (or pass -sort to sort them)"
+	if err.Error() != expectedErrorMessage {
+		t.Errorf("Unexpected error resulted from out-of-order transaction. Expected: \"%s\" ... got \"%s\" instead", expectedErrorMessage, err.Error())
+	}
+	if len(resultingLots) > 0 {
+		t.Errorf("Non-empty lot slice was returned despite out-of-order transaction")
+	}
+
+	// Transactions on the same date may appear in any order
+	if _, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-01,sell,20000.00,0.50000000", "2021-01-01,buy,10000.00,1.00000000"}, "fifo"); err != nil {
+		t.Errorf("Same-date transactions unexpectedly elicited an error: %s", err.Error())
+	}
+
+	// Blank and comment lines are skipped, but still count towards line numbers
+	_, _, err = ProcessTransactions([]string{"# BTC", "2021-01-02,buy,20000.00,1.00000000", "", "2021-01-01,buy,10000.00,1.00000000"}, "fifo")
+	if err == nil || !strings.Contains(err.Error(), "Transaction on line 4 is dated 2021-01-01") {
+		t.Errorf("Unexpected error resulted from out-of-order transaction after skipped lines. Expected an error naming line 4 ... got \"%v\" instead", err)
+	}
+}
+
+func TestSortTransactions(t *testing.T) {
+	transactions := []string{
+		"2021-01-03,buy,30000.00,1.00000000",
+		"2021-01-02,sell,25000.00,0.50000000",
+		"2021-01-02,buy,20000.00,1.00000000",
+		"2021-01-01,buy,10000.00,1.00000000",
+		"2021-01-02,buy,21000.00,1.00000000",
+	}
+	testCases := []struct {
+		order    SameDateOrder
+		expected []string
+	}{
+		{SameDateBuysFirst, []string{transactions[3], transactions[2], transactions[4], transactions[1], transactions[0]}},
+		{SameDateSellsFirst, []string{transactions[3], transactions[1], transactions[2], transactions[4], transactions[0]}},
+		{SameDateInputOrder, []string{transactions[3], transactions[1], transactions[2], transactions[4], transactions[0]}},
+	}
+	for _, testCase := range testCases {
+		sorted, err := SortTransactions(transactions, testCase.order)
+		if err != nil {
+			t.Errorf("SortTransactions (%s): %s", testCase.order, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(sorted, testCase.expected) {
+			t.Errorf("SortTransactions (%s): Expected %v ... got %v instead", testCase.order, testCase.expected, sorted)
+		}
+	}
+
+	// Once sorted, the transactions can be processed
+	sorted, _ := SortTransactions(transactions, SameDateBuysFirst)
+	resultingLots, _, err := ProcessTransactions(sorted, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	want := "1,2021-01-01,10000.00,0.50000000"
+	if len(resultingLots) != 3 || resultingLots[0].String() != want {
+		t.Errorf("ProcessTransactions: Expected first of 3 remaining lots to be %s ... got %v instead", want, resultingLots)
+	}
+
+	_, err = SortTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,oops,10000.00,1.00000000"}, SameDateBuysFirst)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("SortTransactions: Expected an error naming line 2 ... got %v instead", err)
+	}
+
+	sorted, err = SortTransactions([]string{"# BTC", "2021-01-02,buy,10000.00,1.00000000", "", "2021-01-01,buy,10000.00,1.00000000"}, SameDateBuysFirst)
+	expected := []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,10000.00,1.00000000"}
+	if err != nil || !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("SortTransactions: Expected blank and comment lines to be dropped, leaving %v ... got %v (error: %v) instead", expected, sorted, err)
+	}
+}
+
+func TestProcessingErrorsNameLines(t *testing.T) {
+	testCases := []struct {
+		transactions         []string
+		algorithm            string
+		expectedErrorSnippet string
+	}{
+		{[]string{"2021-01-01,buy,10000.00,1.00000000", "", "2021-01-03,buy,0,1.00000000"}, "fifo", "Problem processing transaction on line 3: Invalid price"},
+		{[]string{"2021-01-01,buy,10000.00,1.00000000", "# sold", "2021-01-03,sell,10000.00,2.00000000"}, "hifo", "Problem processing transaction on line 3: Problem executing sale (hifo)"},
+		{[]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-03,sell,10000.00,1.00000000,,,,7"}, "specid", "Problem processing transaction on line 2: Problem executing sale (specid)"},
+		{[]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-03,sell,10000.00,2.00000000"}, "uk", "Problem processing transaction on line 2: Problem executing sale (uk)"},
+		{[]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-03,split,0,2:1"}, "uk", "Problem processing transaction on line 2: Splits are not supported by the uk algorithm"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactions(testCase.transactions, testCase.algorithm)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactions (%s): Expected error containing \"%s\" ... got \"%v\" instead", testCase.algorithm, testCase.expectedErrorSnippet, err)
+		}
+	}
+
+	// Sorted transactions still name their lines in the unsorted log
+	sorted, err := SortTransactionLog([]string{"2021-01-03,sell,10000.00,2.00000000", "2021-01-01,buy,10000.00,1.00000000"}, SameDateBuysFirst)
+	if err != nil {
+		t.Fatalf("SortTransactionLog: %s", err.Error())
+	}
+	if _, _, err := Process(sorted, "fifo", Options{}); err == nil || !strings.Contains(err.Error(), "on line 1:") {
+		t.Errorf("Process: Expected an error naming line 1 ... got %v instead", err)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	ledger, err := NewLedger("fifo", Options{})
+	if err != nil {
+		t.Fatalf("NewLedger: %s", err.Error())
+	}
+	transactions := []Transaction{
+		{Date: mustParseDate("2021-01-01"), Type: Buy, Price: DecimalFromInt(10000), Quantity: DecimalFromInt(1)},
+		{Date: mustParseDate("2021-01-02"), Type: Buy, Price: DecimalFromInt(20000), Quantity: DecimalFromInt(1), Symbol: "BTC"},
+		{Date: mustParseDate("2021-02-01"), Type: Sell, Price: DecimalFromInt(15000), Quantity: mustParseDecimal("0.5")},
+	}
+	for _, tx := range transactions[:2] {
+		if disposals, err := ledger.Apply(tx); err != nil || len(disposals) != 0 {
+			t.Fatalf("Ledger.Apply: Expected a buy to realize no disposals ... got %v (error: %v) instead", disposals, err)
+		}
+	}
+	disposals, err := ledger.Apply(transactions[2])
+	if err != nil {
+		t.Fatalf("Ledger.Apply: %s", err.Error())
+	}
+	want := "1,2021-01-01,2021-02-01,0.50000000,5000.00,7500.00,2500.00"
+	if len(disposals) != 1 || disposals[0].String() != want {
+		t.Errorf("Ledger.Apply: Expected a single disposal %s ... got %v instead", want, disposals)
+	}
+	if got := ledger.Disposals(); len(got) != 1 || got[0].String() != want {
+		t.Errorf("Ledger.Disposals: Expected a single disposal %s ... got %v instead", want, got)
+	}
+
+	expectedLots := []string{"1,2021-01-01,10000.00,0.50000000", "1,2021-01-02,20000.00,1.00000000,BTC"}
+	lots := ledger.Lots()
+	if len(lots) != len(expectedLots) {
+		t.Fatalf("Ledger.Lots: Expected %d lots back, got %v instead", len(expectedLots), lots)
+	}
+	for idx, want := range expectedLots {
+		if got := lots[idx].String(); got != want {
+			t.Errorf("Ledger.Lots: Expected lots[%d].String() to be %s ... got %s instead", idx, want, got)
+		}
+	}
+
+	_, err = ledger.Apply(Transaction{Date: mustParseDate("2021-01-15"), Type: Buy, Price: DecimalFromInt(1), Quantity: DecimalFromInt(1)})
+	expectedErrorSnippet := "Transaction dated 2021-01-15 is before the preceding transaction (2021-02-01)"
+	if err == nil || !strings.Contains(err.Error(), expectedErrorSnippet) {
+		t.Errorf("Ledger.Apply: Expected error containing \"%s\" ... got \"%v\" instead", expectedErrorSnippet, err)
+	}
+}
+
+func TestNewLedgerErrors(t *testing.T) {
+	testCases := []struct {
+		algorithm            string
+		options              Options
+		expectedErrorSnippet string
+	}{
+		{"lofi", Options{}, "Invalid algorithm"},
+		{"uk", Options{}, "The uk algorithm looks ahead in the transaction log, so it cannot process transactions one at a time"},
+		{"average", Options{WashSales: true}, "Wash sale detection is not supported by the average algorithm"},
+	}
+	for _, testCase := range testCases {
+		_, err := NewLedger(testCase.algorithm, testCase.options)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("NewLedger (%s): Expected error containing \"%s\" ... got \"%v\" instead", testCase.algorithm, testCase.expectedErrorSnippet, err)
+		}
+	}
+}
+
+func TestProcessTypedTransactions(t *testing.T) {
+	// Unlike a Ledger, Process supports algorithms that look ahead in the log
+	lots, disposals, err := Process([]Transaction{
+		{Date: mustParseDate("2021-01-01"), Type: Buy, Price: DecimalFromInt(100), Quantity: DecimalFromInt(2)},
+		{Date: mustParseDate("2021-01-02"), Type: Sell, Price: DecimalFromInt(150), Quantity: DecimalFromInt(1)},
+		{Date: mustParseDate("2021-01-10"), Type: Buy, Price: DecimalFromInt(120), Quantity: DecimalFromInt(1)},
+	}, "uk", Options{})
+	if err != nil {
+		t.Fatalf("Process: %s", err.Error())
+	}
+	if len(disposals) != 1 || disposals[0].Rule != UKRuleBedAndBreakfast {
+		t.Errorf("Process: Expected a single bed-and-breakfast disposal ... got %v instead", disposals)
+	}
+	want := "1,2021-01-01,100.00,2.00000000"
+	if len(lots) != 1 || lots[0].String() != want {
+		t.Errorf("Process: Expected a single lot %s ... got %v instead", want, lots)
+	}
+}
+
+func TestProcessStream(t *testing.T) {
+	transactionLog := []string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-03-01,buy,60.00,5.00000000",
+		"2021-03-10,sell,80.00,10.00000000",
+		"2021-03-20,buy,70.00,2.00000000",
+		"2021-04-01,buy,20.00,3.00000000,BTC",
+		"2021-04-01,sell,90.00,1.00000000,BTC",
+		"2021-05-01,buy,50.00,1.00000000",
+	}
+	for _, options := range []Options{{}, {WashSales: true}} {
+		expectedLots, expectedDisposals, err := ProcessTransactionsWithOptions(transactionLog, "fifo", options)
+		if err != nil {
+			t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+		}
+
+		var disposals []Disposal
+		lots, err := ProcessStream(strings.NewReader(strings.Join(transactionLog, "\n")), "fifo", options, func(disposal Disposal) error {
+			disposals = append(disposals, disposal)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ProcessStream: %s", err.Error())
+		}
+		if !reflect.DeepEqual(lots, expectedLots) {
+			t.Errorf("ProcessStream (%+v): Expected lots %v ... got %v instead", options, expectedLots, lots)
+		}
+		if !reflect.DeepEqual(disposals, expectedDisposals) {
+			t.Errorf("ProcessStream (%+v): Expected disposals %v ... got %v instead", options, expectedDisposals, disposals)
+		}
+	}
+
+	testCases := []struct {
+		input                string
+		algorithm            string
+		expectedErrorSnippet string
+	}{
+		{"2021-01-01,buy,10000.00,1.00000000\n2021-01-02,oops,10000.00,1.00000000", "fifo", "Problem parsing raw transaction on line 2 (2021-01-02,oops,10000.00,1.00000000)"},
+		{"2021-01-02,buy,10000.00,1.00000000\n2021-01-01,buy,10000.00,1.00000000", "fifo", "Transaction on line 2 is dated 2021-01-01"},
+		{"# BTC\n2021-01-02,buy,10000.00,1.00000000\n\n2021-01-01,buy,10000.00,1.00000000", "fifo", "Transaction on line 4 is dated 2021-01-01"},
+		{"2021-01-01,buy,10000.00,1.00000000\n2021-01-02,sell,10000.00,2.00000000", "fifo", "Problem processing transaction on line 2: Problem executing sale (fifo): Sale quantity exceeded total buy quantity"},
+		{"2021-01-01,buy,10000.00,1.00000000\n\n2021-01-03,buy,0,1.00000000", "fifo", "Problem processing transaction on line 3: Invalid price"},
+		{"2021-01-01,buy,10000.00,1.00000000", "uk", "cannot process transactions one at a time"},
+		{"2021-01-01,buy,10000.00,1.00000000\n2021-01-02,sell,10000.00,1.00000000", "fifo", "handler failed"},
+	}
+	for _, testCase := range testCases {
+		_, err := ProcessStream(strings.NewReader(testCase.input), testCase.algorithm, Options{}, func(Disposal) error {
+			return fmt.Errorf("handler failed")
+		})
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessStream: Expected error containing \"%s\" ... got \"%v\" instead", testCase.expectedErrorSnippet, err)
+		}
+	}
+}
+
+func TestTakeSettledDisposals(t *testing.T) {
+	ledger, err := NewLedger("fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("NewLedger: %s", err.Error())
+	}
+	transactions := []struct {
+		tx              Transaction
+		expectedSettled int
+	}{
+		{Transaction{Date: mustParseDate("2021-01-01"), Type: Buy, Price: DecimalFromInt(100), Quantity: DecimalFromInt(2)}, 0},
+		{Transaction{Date: mustParseDate("2021-02-01"), Type: Sell, Price: DecimalFromInt(80), Quantity: DecimalFromInt(1)}, 0},
+		// Still within 30 days of the sale, so the loss may yet be disallowed
+		{Transaction{Date: mustParseDate("2021-03-03"), Type: Buy, Price: DecimalFromInt(90), Quantity: DecimalFromInt(1)}, 0},
+		{Transaction{Date: mustParseDate("2021-03-04"), Type: Buy, Price: DecimalFromInt(90), Quantity: DecimalFromInt(1)}, 1},
+	}
+	for idx, testCase := range transactions {
+		if _, err := ledger.Apply(testCase.tx); err != nil {
+			t.Fatalf("Ledger.Apply: %s", err.Error())
+		}
+		settled := ledger.TakeSettledDisposals()
+		if len(settled) != testCase.expectedSettled {
+			t.Fatalf("Ledger.TakeSettledDisposals: Expected %d settled disposals after transaction #%d ... got %v instead", testCase.expectedSettled, idx, settled)
+		}
+		if len(settled) == 1 && settled[0].DisallowedLoss.Cmp(DecimalFromInt(20)) != 0 {
+			t.Errorf("Ledger.TakeSettledDisposals: Expected a disallowed loss of 20.00 ... got %s instead", settled[0].DisallowedLoss)
+		}
+	}
+	if remaining := ledger.Disposals(); len(remaining) != 0 {
+		t.Errorf("Ledger.Disposals: Expected no disposals to remain after taking them ... got %v instead", remaining)
+	}
+}
+
+// A generatedLog is a transaction log of a buy and a sale of a single unit every day, generated as it is read
+// Lots are sold as soon as they are bought (at a loss every other day), so the number of open lots stays bounded
+type generatedLog struct {
+	days    int
+	day     int
+	pending []byte
+}
+
+func (log *generatedLog) Read(p []byte) (int, error) {
+	for len(log.pending) == 0 {
+		if log.day == log.days {
+			return 0, io.EOF
+		}
+		date := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, log.day).Format(DateLayout)
+		log.pending = []byte(fmt.Sprintf("%s,buy,%d.00,1.00000000,BTC\n%s,sell,%d.00,1.00000000,BTC\n", date, 100+log.day%7, date, 100+log.day%2*10))
+		log.day++
+	}
+	n := copy(p, log.pending)
+	log.pending = log.pending[n:]
+	return n, nil
+}
+
+func BenchmarkProcessStream(b *testing.B) {
+	for _, options := range []Options{{}, {WashSales: true}} {
+		b.Run(fmt.Sprintf("WashSales=%t", options.WashSales), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				var count int
+				_, err := ProcessStream(&generatedLog{days: 100000}, "fifo", options, func(Disposal) error {
+					count++
+					return nil
+				})
+				if err != nil {
+					b.Fatalf("ProcessStream: %s", err.Error())
+				}
+				if count == 0 {
+					b.Fatalf("ProcessStream: Expected disposals to be realized")
+				}
+			}
+		})
+	}
+}
+
+func TestRejectInvalidValues(t *testing.T) {
+	testCases := []struct {
+		rawTx                string
+		options              Options
+		expectedErrorSnippet string
+	}{
+		{"2021-1-01,buy,100.00,1.00000000", Options{}, "Invalid date (must be in YYYY-MM-DD format): 2021-1-01"},
+		{"2021-02-29,buy,100.00,1.00000000", Options{}, "Invalid date (must be in YYYY-MM-DD format): 2021-02-29"},
+		{"2021-01-01T00:00:00Z,buy,100.00,1.00000000", Options{}, "Invalid date (must be in YYYY-MM-DD format)"},
+		{"2021-01-01,buy,NaN,1.00000000", Options{}, "Invalid (non-float) price: NaN"},
+		{"2021-01-01,buy,+Inf,1.00000000", Options{}, "Invalid (non-float) price: +Inf"},
+		{"2021-01-01,buy,-100.00,1.00000000", Options{}, "Invalid price (must not be negative): -100.00"},
+		{"2021-01-01,buy,0,1.00000000", Options{}, "Invalid price (must be positive, unless zero-price buys such as gifts are allowed with -allow-zero-price)"},
+		{"2021-01-01,buy,Inf,1.00000000", Options{AllowZeroPriceBuys: true}, "Invalid (non-float) price: Inf"},
+		{"2021-01-01,buy,100.00,NaN", Options{}, "Invalid (non-float) quantity: NaN"},
+		{"2021-01-01,buy,100.00,-Inf", Options{}, "Invalid (non-float) quantity: -Inf"},
+		{"2021-01-01,buy,100.00,0", Options{}, "Invalid quantity (must be positive): 0"},
+		{"2021-01-01,buy,100.00,-1.00000000", Options{}, "Invalid quantity (must be positive): -1.00000000"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactionsWithOptions([]string{testCase.rawTx}, "fifo", testCase.options)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected error containing \"%s\" ... got \"%v\" instead", testCase.rawTx, testCase.expectedErrorSnippet, err)
+		}
+	}
+
+	// Zero-price buys (e.g. gifts) are accepted once allowed, but zero-price sales never are
+	for _, algorithm := range []string{"fifo", "uk"} {
+		lots, _, err := ProcessTransactionsWithOptions([]string{"2021-01-01,buy,0,1.00000000"}, algorithm, Options{AllowZeroPriceBuys: true})
+		want := "1,2021-01-01,0.00,1.00000000"
+		if err != nil || len(lots) != 1 || lots[0].String() != want {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected a single lot %s ... got %v (error: %v) instead", algorithm, want, lots, err)
+		}
+		_, _, err = ProcessTransactionsWithOptions([]string{"2021-01-01,buy,0,1.00000000", "2021-01-02,sell,0,1.00000000"}, algorithm, Options{AllowZeroPriceBuys: true})
+		expectedErrorSnippet := "Invalid price (must be positive): 0.00000000"
+		if err == nil || !strings.Contains(err.Error(), expectedErrorSnippet) {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected error containing \"%s\" ... got \"%v\" instead", algorithm, expectedErrorSnippet, err)
+		}
+	}
+
+	// Typed transactions skip parsing, so the ledger checks them as well
+	ledger, err := NewLedger("fifo", Options{})
+	if err != nil {
+		t.Fatalf("NewLedger: %s", err.Error())
+	}
+	_, err = ledger.Apply(Transaction{Date: mustParseDate("2021-01-01"), Type: Buy, Price: DecimalFromInt(100)})
+	if err == nil || !strings.Contains(err.Error(), "Invalid quantity (must be positive)") {
+		t.Errorf("Ledger.Apply: Expected an invalid quantity error ... got %v instead", err)
+	}
+}
+
+func TestOpeningLots(t *testing.T) {
+	openingLots := []Lot{
+		{ID: 3, Date: mustParseDate("2020-06-01"), Price: DecimalFromInt(200), Quantity: DecimalFromInt(1)},
+		{ID: 1, Date: mustParseDate("2020-01-01"), Price: DecimalFromInt(100), Quantity: DecimalFromInt(2)},
+		{ID: 1, Date: mustParseDate("2020-02-01"), Price: DecimalFromInt(50), Quantity: DecimalFromInt(1), Symbol: "ETH"},
+	}
+	transactionLog := []string{
+		"2021-01-05,buy,300.00,1.00000000",
+		"2021-02-01,sell,400.00,2.50000000",
+		"2021-02-02,buy,60.00,1.00000000,ETH",
+	}
+	testCases := []struct {
+		algorithm         string
+		expectedLots      []string
+		expectedDisposals int
+	}{
+		// Opening lots keep their ids and dates, and are sold in chronological order; new lots are numbered after them
+		{"fifo", []string{"3,2020-06-01,200.00,0.50000000", "4,2021-01-05,300.00,1.00000000", "1,2020-02-01,50.00,1.00000000,ETH", "2,2021-02-02,60.00,1.00000000,ETH"}, 2},
+		{"lifo", []string{"1,2020-01-01,100.00,1.50000000", "1,2020-02-01,50.00,1.00000000,ETH", "2,2021-02-02,60.00,1.00000000,ETH"}, 3},
+		// Pooling algorithms pool the opening lots, keeping the id and date of the earliest
+		{"average", []string{"1,2020-01-01,175.00,1.50000000", "1,2020-02-01,55.00,2.00000000,ETH"}, 1},
+		{"uk", []string{"1,2020-01-01,175.00,1.50000000", "1,2020-02-01,55.00,2.00000000,ETH"}, 1},
+	}
+	for _, testCase := range testCases {
+		lots, disposals, err := ProcessTransactionsWithOptions(transactionLog, testCase.algorithm, Options{OpeningLots: openingLots})
+		if err != nil {
+			t.Fatalf("ProcessTransactionsWithOptions (%s): %s", testCase.algorithm, err.Error())
+		}
+		if len(disposals) != testCase.expectedDisposals {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected %d disposals ... got %v instead", testCase.algorithm, testCase.expectedDisposals, disposals)
+		}
+		formatted := make([]string, len(lots))
+		for idx, lot := range lots {
+			formatted[idx] = lot.String()
+		}
+		if !reflect.DeepEqual(formatted, testCase.expectedLots) {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected lots %v ... got %v instead", testCase.algorithm, testCase.expectedLots, formatted)
+		}
+	}
+
+	duplicated := append([]Lot{{ID: 1, Date: mustParseDate("2020-03-01"), Price: DecimalFromInt(1), Quantity: DecimalFromInt(1)}}, openingLots...)
+	for _, algorithm := range []string{"fifo", "uk"} {
+		_, _, err := ProcessTransactionsWithOptions(transactionLog, algorithm, Options{OpeningLots: duplicated})
+		if err == nil || !strings.Contains(err.Error(), "Opening lot 1 appears more than once") {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected a duplicate opening lot error ... got %v instead", algorithm, err)
+		}
+	}
+
+	// Losses realized before the opening lots are not carried forward, so wash sales cannot be detected
+	if _, _, err := ProcessTransactionsWithOptions(transactionLog, "fifo", Options{OpeningLots: openingLots, WashSales: true}); err == nil || !strings.Contains(err.Error(), "not supported with opening lots") {
+		t.Errorf("ProcessTransactionsWithOptions: Expected wash sale detection with opening lots to be rejected ... got %v instead", err)
+	}
+
+	// Opening lots count as held when validating
+	problems, err := Validate(strings.NewReader(strings.Join(transactionLog, "\n")), "fifo", Options{OpeningLots: openingLots}, "")
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Validate: Expected no problems with opening lots ... got %v (error: %v) instead", problems, err)
+	}
+
+	// Lots carried forward in json keep their buy fees, and the ids of lots sold off are not reused
+	previousLots, _, err := ProcessTransactions([]string{
+		"2021-01-01,buy,100.00,2.00000000,,10.00,USD",
+		"2021-01-02,buy,110.00,1.00000000",
+		"2021-01-03,buy,120.00,1.00000000",
+		"2021-01-04,sell,130.00,1.00000000",
+	}, "lifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	var carried bytes.Buffer
+	if err := WriteLots(&carried, previousLots, OutputFormatJSON); err != nil {
+		t.Fatalf("WriteLots: %s", err.Error())
+	}
+	carriedLots, err := ParseLots(&carried)
+	if err != nil {
+		t.Fatalf("ParseLots: %s", err.Error())
+	}
+	lots, disposals, err := ProcessTransactionsWithOptions([]string{"2022-01-01,buy,140.00,1.00000000", "2022-01-02,sell,150.00,1.00000000"}, "fifo", Options{OpeningLots: carriedLots})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+	if len(lots) != 3 || lots[2].String() != "4,2022-01-01,140.00,1.00000000" {
+		t.Errorf("ProcessTransactionsWithOptions: Expected the lot bought to be numbered 4, after the lot sold off ... got %v instead", lots)
+	}
+	if len(disposals) != 1 || disposals[0].AcquisitionFee.String() != "5.00000000" {
+		t.Errorf("ProcessTransactionsWithOptions: Expected the sale to include 5.00 of the opening lot's buy fee ... got %v instead", disposals)
+	}
+}
@@ -0,0 +1,326 @@
+package taxlot
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// A Lot is an open tax lot: the remaining quantity of one or more buys of an asset on the same date
+type Lot struct {
+	ID       int
+	Date     time.Time
+	Price    Decimal
+	Quantity Decimal
+	Symbol   string
+	// Account (or wallet) holding the lot; empty for the single unnamed account
+	Account string
+	// Portion of buy fees (in the price currency) included in the remaining quantity's basis, and the label of their currency
+	Fee         Decimal
+	FeeCurrency string
+	// Start of the lot's holding period, if earlier than its date (wash sale replacement lots inherit the holding period
+	// of the shares they replace); zero means the holding period starts on date
+	HoldingStart time.Time
+	// Id of the next lot of the asset in the account, as listed by Book.Lots: ids of lots sold off are never reused, so the
+	// next id may be beyond the highest id remaining; zero if it follows the highest id remaining (or is unknown)
+	NextID int
+	// Whether the lot is the replacement for a wash sale, so it may not replace the shares of another wash sale
+	washReplacement bool
+}
+
+// Lots are formatted as id,date,price,quantity, followed by the asset symbol if one was given, and the account if one was
+// given (after an empty symbol if there was none)
+func (lot Lot) String() string {
+	formatted := fmt.Sprintf("%d,%s,%s,%s", lot.ID, lot.Date.Format(DateLayout), lot.Price.StringFixed(2), lot.Quantity.StringFixed(8))
+	if lot.Symbol != "" || lot.Account != "" {
+		formatted += "," + lot.Symbol
+	}
+	if lot.Account != "" {
+		formatted += "," + lot.Account
+	}
+	return formatted
+}
+
+// Function to find the start of the lot's holding period, which is its date unless the lot replaced the shares of a wash sale
+func (lot Lot) HoldingPeriodStart() time.Time {
+	if lot.HoldingStart.IsZero() {
+		return lot.Date
+	}
+	return lot.HoldingStart
+}
+
+// A Book holds the open lots of a single asset, in chronological (date, then id) order
+// The zero value is an empty book, ready to use
+type Book struct {
+	lots []Lot
+	// Number of lots ever created in this book; lot ids keep incrementing even as lots are sold off, so they are never reused
+	lotCount int
+}
+
+// Function to list the open lots of the book, in chronological order
+// Lots carry the id of the next lot only if it does not follow the highest id remaining, as the highest lots were sold off
+func (book *Book) Lots() []Lot {
+	lots := append([]Lot(nil), book.lots...)
+	highestID := 0
+	for _, lot := range lots {
+		if lot.ID > highestID {
+			highestID = lot.ID
+		}
+	}
+	if book.lotCount > highestID {
+		for idx := range lots {
+			lots[idx].NextID = book.lotCount + 1
+		}
+	}
+	return lots
+}
+
+// Function to add a purchase to the book, either as a new lot or aggregated into an existing lot
+// Buys on the same date as the most recent lot are aggregated into it; with a pooling algorithm (average), every buy
+// is aggregated into the asset's single pooled lot
+// Buy fees are folded into the lot's price, so that they increase its cost basis
+func (book *Book) Buy(tx Transaction, selector LotSelector) {
+	newLot := Lot{Date: tx.Date, Price: tx.Price, Quantity: tx.Quantity, Symbol: tx.Symbol, Account: tx.Account, Fee: tx.Fee, FeeCurrency: tx.FeeCurrency}
+	if newLot.Fee.Sign() != 0 && newLot.Quantity.Sign() != 0 {
+		newLot.Price = newLot.Price.Add(newLot.Fee.Div(newLot.Quantity))
+	}
+	_, pooled := selector.(averageSelector)
+	if len(book.lots) == 0 || (!pooled && (!book.lots[len(book.lots)-1].Date.Equal(newLot.Date) || book.lots[len(book.lots)-1].washReplacement)) {
+		// Buy lot with never-before-seen date (wash sale replacement lots keep their adjusted price to themselves)
+		book.add(newLot)
+	} else {
+		book.lots[len(book.lots)-1].absorb(newLot)
+	}
+}
+
+// Function to add an opening lot (e.g. a lot remaining after a previous run) to the book, keeping its id, date and price
+// Lots added later are numbered from its NextID if given, and otherwise after the highest id in the book; with a pooling algorithm (average), opening lots are
+// pooled together, keeping the id and date of the earliest
+func (book *Book) Open(lot Lot, selector LotSelector) error {
+	for _, existing := range book.lots {
+		if existing.ID == lot.ID {
+			return fmt.Errorf("Opening lot %d appears more than once", lot.ID)
+		}
+	}
+	if lot.ID > book.lotCount {
+		book.lotCount = lot.ID
+	}
+	if lot.NextID > book.lotCount+1 {
+		book.lotCount = lot.NextID - 1
+	}
+	lot.NextID = 0
+	if _, pooled := selector.(averageSelector); pooled && len(book.lots) != 0 {
+		if lot.Date.Before(book.lots[0].Date) {
+			lot, book.lots[0] = book.lots[0], lot
+		}
+		book.lots[0].absorb(lot)
+		return nil
+	}
+	book.lots = append(book.lots, lot)
+	book.sortChronologically()
+	return nil
+}
+
+// Function to add newLot to the book as a lot of its own, with the next lot id
+func (book *Book) add(newLot Lot) {
+	book.lotCount++
+	newLot.ID = book.lotCount
+	book.lots = append(book.lots, newLot)
+}
+
+// Function to sort the book's lots back to default chronological ordering
+// Lot ids are chronological, except for lots split off an older lot (see washSaleReplacement), so dates are compared first
+func (book *Book) sortChronologically() {
+	sort.SliceStable(book.lots, func(i, j int) bool {
+		if !book.lots[i].Date.Equal(book.lots[j].Date) {
+			return book.lots[i].Date.Before(book.lots[j].Date)
+		}
+		return book.lots[i].ID < book.lots[j].ID
+	})
+}
+
+// Function to aggregate newLot into lot, which keeps its id and date
+// Aggregated lots make up a single lot with a weighted-average price, and the sum of both lots' fees
+func (lot *Lot) absorb(newLot Lot) {
+	lot.Price = weightedPrice(*lot, newLot)
+	lot.Quantity = lot.Quantity.Add(newLot.Quantity)
+	lot.Fee = lot.Fee.Add(newLot.Fee)
+	if lot.FeeCurrency == "" {
+		lot.FeeCurrency = newLot.FeeCurrency
+	}
+}
+
+// Function to sell from the book, consuming lots in the order decided by selector
+// Lots designated by the sale (specific identification) are consumed before any others
+// Returns a Disposal record for every (possibly partial) lot consumed by the sale
+func (book *Book) Sell(sale Transaction, selector LotSelector) ([]Disposal, error) {
+	// The sale works on a copy of the lots, so that the book is left unchanged if it fails
+	designated, lots, err := takeDesignated(append([]Lot(nil), book.lots...), sale, selector)
+	if err != nil {
+		return nil, err
+	}
+	selector.Prioritize(lots)
+	lots, disposals, err := ExecuteSale(append(designated, lots...), sale)
+	if err != nil {
+		return nil, err
+	}
+	// After processing, sort lots back to default chronological ordering
+	book.lots = lots
+	book.sortChronologically()
+	return disposals, nil
+}
+
+// Function to remove the portions of lots designated by sale from lots (which is modified in place), returning them in
+// designation order along with the lots left
+// Designated portions keep the id, date and price of the lot they were taken from
+func takeDesignated(lots []Lot, sale Transaction, selector LotSelector) ([]Lot, []Lot, error) {
+	if len(sale.Designations) == 0 {
+		return nil, lots, nil
+	}
+	if _, ok := selector.(specIDSelector); !ok {
+		return nil, nil, fmt.Errorf("Sale designates lots, which requires the \"specid\" algorithm")
+	}
+
+	var designated []Lot
+	var designatedQuantity Decimal
+	for _, designation := range sale.Designations {
+		idx := 0
+		for idx < len(lots) && lots[idx].ID != designation.LotID {
+			idx++
+		}
+		if idx == len(lots) {
+			return nil, nil, fmt.Errorf("Designated lot %d does not exist (or has already been sold)", designation.LotID)
+		}
+		lot := &lots[idx]
+		quantity := designation.Quantity
+		if quantity.Sign() == 0 {
+			quantity = lot.Quantity
+		}
+		if quantity.Cmp(lot.Quantity) > 0 {
+			return nil, nil, fmt.Errorf("Designated lot %d has only %s remaining, which is less than the designated %s", designation.LotID, lot.Quantity, quantity)
+		}
+		designatedQuantity = designatedQuantity.Add(quantity)
+		if designatedQuantity.Cmp(sale.Quantity) > 0 {
+			return nil, nil, fmt.Errorf("Designated lot quantities exceed the sale quantity of %s", sale.Quantity)
+		}
+
+		portion := *lot
+		portion.Quantity = quantity
+		portion.Fee = lot.Fee.proRata(quantity, lot.Quantity)
+		designated = append(designated, portion)
+		lot.Quantity = lot.Quantity.Sub(quantity)
+		lot.Fee = lot.Fee.Sub(portion.Fee)
+		if lot.Quantity.Sign() == 0 {
+			lots = append(lots[:idx], lots[idx+1:]...)
+		}
+	}
+	return designated, lots, nil
+}
+
+// A Disposal records the portion of a single lot consumed by a sale, along with the resulting realized gain (or loss)
+type Disposal struct {
+	LotID    int
+	Symbol   string
+	Account  string
+	Acquired time.Time
+	Sold     time.Time
+	// Start of the holding period of the lot, which differs from acquired for wash sale replacement lots
+	HoldingStart time.Time
+	Quantity     Decimal
+	// Per-unit cost basis of the lot at the time of the sale (for the average algorithm, the pool's average cost)
+	UnitCost Decimal
+	// Cost basis includes acquisitionFee, and proceeds are net of saleFee
+	CostBasis      Decimal
+	Proceeds       Decimal
+	AcquisitionFee Decimal
+	SaleFee        Decimal
+	FeeCurrency    string
+	// Share matching rule the disposal was matched under (uk algorithm only; see matchUKDisposals)
+	Rule string
+	// Portion of the loss disallowed by the wash sale rule, which is added to the basis of the replacement lots instead
+	DisallowedLoss Decimal
+}
+
+// Function to build the Disposal record for quantity units of lot being consumed by sale
+// The fees of both the lot and the sale are allocated in proportion to the quantity consumed
+func newDisposal(lot Lot, sale Transaction, quantity Decimal) Disposal {
+	saleFee := sale.Fee.proRata(quantity, sale.Quantity)
+	feeCurrency := sale.FeeCurrency
+	if feeCurrency == "" {
+		feeCurrency = lot.FeeCurrency
+	}
+	return Disposal{
+		LotID:          lot.ID,
+		Symbol:         lot.Symbol,
+		Account:        lot.Account,
+		Acquired:       lot.Date,
+		HoldingStart:   lot.HoldingPeriodStart(),
+		Sold:           sale.Date,
+		Quantity:       quantity,
+		UnitCost:       lot.Price,
+		CostBasis:      lot.Price.Mul(quantity),
+		Proceeds:       sale.Price.Mul(quantity).Sub(saleFee),
+		AcquisitionFee: lot.Fee.proRata(quantity, lot.Quantity),
+		SaleFee:        saleFee,
+		FeeCurrency:    feeCurrency,
+	}
+}
+
+// Realized gain of the disposal; negative values are losses
+// Losses disallowed by the wash sale rule are not realized, so they are excluded
+func (disposal Disposal) Gain() Decimal {
+	return disposal.Proceeds.Sub(disposal.CostBasis).Add(disposal.DisallowedLoss)
+}
+
+// Holding period classification of the disposal (ShortTerm or LongTerm), given the long-term threshold
+func (disposal Disposal) Term(longTermThreshold HoldingPeriod) string {
+	return longTermThreshold.Classify(disposal.HoldingStart, disposal.Sold)
+}
+
+func (disposal Disposal) String() string {
+	return fmt.Sprintf("%d,%s,%s,%s,%s,%s,%s", disposal.LotID, disposal.Acquired.Format(DateLayout), disposal.Sold.Format(DateLayout), disposal.Quantity.StringFixed(8), disposal.CostBasis.StringFixed(2), disposal.Proceeds.StringFixed(2), disposal.Gain().StringFixed(2))
+}
+
+// Function to calculate weighted price of lot in cases where multiple buys occurred on the same date
+// The total cost of both lots is computed exactly and divided by the total quantity, so the result is rounded only once
+func weightedPrice(oldLot Lot, newLot Lot) Decimal {
+	totalCost := new(big.Int).Mul(oldLot.Price.bigInt(), oldLot.Quantity.bigInt())
+	totalCost.Add(totalCost, new(big.Int).Mul(newLot.Price.bigInt(), newLot.Quantity.bigInt()))
+	quantityTotal := oldLot.Quantity.Add(newLot.Quantity).bigInt()
+	return roundQuotient(totalCost, quantityTotal)
+}
+
+// Function to execute a single sale transaction, subtracting the sale's quantity from existing tax lots
+// Returns the remaining lots along with a Disposal record for every (possibly partial) lot consumed by the sale
+// Note: this function assumes that the lots are sorted such that the head of the slice is prioritized
+// which means that it is the responsibility of the calling function to sort lots before calling ExecuteSale
+func ExecuteSale(lots []Lot, sale Transaction) ([]Lot, []Disposal, error) {
+	// sale is a copy, so its quantity and fee can be counted down as they are allocated to each lot consumed
+	var disposals []Disposal
+	for sale.Quantity.Sign() > 0 && len(lots) > 0 {
+		var disposal Disposal
+		if lots[0].Quantity.Cmp(sale.Quantity) > 0 {
+			disposal = newDisposal(lots[0], sale, sale.Quantity)
+			lots[0].Quantity = lots[0].Quantity.Sub(sale.Quantity)
+			lots[0].Fee = lots[0].Fee.Sub(disposal.AcquisitionFee)
+			sale.Quantity = Decimal{}
+		} else if lots[0].Quantity.Cmp(sale.Quantity) == 0 {
+			disposal = newDisposal(lots[0], sale, sale.Quantity)
+			lots = lots[1:]
+			sale.Quantity = Decimal{}
+		} else {
+			// Reaching here means that lots[0].quantity < sale.quantity
+			disposal = newDisposal(lots[0], sale, lots[0].Quantity)
+			sale.Quantity = sale.Quantity.Sub(lots[0].Quantity)
+			lots = lots[1:]
+		}
+		sale.Fee = sale.Fee.Sub(disposal.SaleFee)
+		disposals = append(disposals, disposal)
+	}
+	if sale.Quantity.Sign() > 0 {
+		// Reaching here means that input contained more sales than buys; interpret as erroneous
+		return nil, nil, fmt.Errorf("Sale quantity exceeded total buy quantity; please ensure that transaction log input is valid")
+	}
+	return lots, disposals, nil
+}
@@ -0,0 +1,81 @@
+package taxlot
+
+import (
+	"fmt"
+	"io"
+)
+
+// A SalePreview is the outcome of a proposed sale under one lot selection algorithm
+type SalePreview struct {
+	Algorithm string
+	// Disposals the sale would realize, one per (partial) lot consumed
+	Disposals []Disposal
+	// Problem processing the transaction log and the sale with the algorithm (e.g. selling more than is held), if any
+	Err error
+}
+
+// Total gain the sale would realize; negative values are losses
+func (preview SalePreview) Gain() Decimal {
+	var total Decimal
+	for _, disposal := range preview.Disposals {
+		total = total.Add(disposal.Gain())
+	}
+	return total
+}
+
+// Function to preview the lots a proposed sale would consume, and the gain it would realize, under every registered
+// algorithm (see LotSelectorNames), as if it followed the transactions of a transaction log parsed by ParseTransactionLog
+// (or SortTransactionLog)
+// Neither the transactions nor the results of processing them are changed
+// Returns a preview per algorithm, in alphabetical order; an algorithm that cannot process the log or the sale (e.g.
+// "average" with wash sale detection) has its problem in the Err of its preview instead of failing the whole preview
+func PreviewSale(transactions []Transaction, sale Transaction, options Options) ([]SalePreview, error) {
+	if sale.Type != Sell {
+		return nil, fmt.Errorf("Invalid proposed sale (must be a sale): %s", sale.Type)
+	}
+	if len(transactions) > 0 && sale.Date.Before(transactions[len(transactions)-1].Date) {
+		return nil, fmt.Errorf("Proposed sale is dated %s, before the last transaction (%s)", sale.Date.Format(DateLayout), transactions[len(transactions)-1].Date.Format(DateLayout))
+	}
+	// The sale is the last transaction, so its disposals follow those of every sale in the log
+	withSale := append(transactions[:len(transactions):len(transactions)], sale)
+
+	names := LotSelectorNames()
+	previews := make([]SalePreview, 0, len(names))
+	for _, name := range names {
+		preview := SalePreview{Algorithm: name}
+		_, before, err := Process(transactions, name, options)
+		if err == nil {
+			var after []Disposal
+			if _, after, err = Process(withSale, name, options); err == nil {
+				preview.Disposals = after[len(before):]
+			}
+		}
+		preview.Err = err
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+// Function to write sale previews to out, one line per lot each algorithm would consume in the format of
+// algorithm,lotId,acquiredDate,soldDate,quantity,costBasis,proceeds,gain,term,symbol,account
+// followed by an algorithm,total,gain line with the total the sale would realize under the algorithm, or a comment line
+// with the problem if the algorithm could not process the sale
+func WriteSalePreviews(out io.Writer, previews []SalePreview, longTermThreshold HoldingPeriod) error {
+	for _, preview := range previews {
+		if preview.Err != nil {
+			if _, err := fmt.Fprintf(out, "# %s: %s\n", preview.Algorithm, preview.Err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, disposal := range preview.Disposals {
+			if _, err := fmt.Fprintf(out, "%s,%s,%s,%s,%s\n", preview.Algorithm, disposal.String(), disposal.Term(longTermThreshold), disposal.Symbol, disposal.Account); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(out, "%s,total,%s\n", preview.Algorithm, preview.Gain().StringFixed(2)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package taxlot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Helper function to parse a transaction log in tests, panicking on malformed input
+func mustParseTransactionLog(transactionLog []string) []Transaction {
+	transactions, err := ParseTransactionLog(transactionLog)
+	if err != nil {
+		panic(err)
+	}
+	return transactions
+}
+
+func TestPreviewSale(t *testing.T) {
+	transactionLog := []string{
+		"2020-01-01,buy,100.00,2.00000000",
+		"# bought on the dip",
+		"2021-01-01,buy,300.00,1.00000000",
+		"2021-03-01,buy,150.00,1.00000000",
+		"2021-04-01,sell,200.00,1.00000000",
+	}
+	sale, err := ParseTransaction("2021-06-01,sell,250.00,2.00000000")
+	if err != nil {
+		t.Fatalf("ParseTransaction: %s", err.Error())
+	}
+	previews, err := PreviewSale(mustParseTransactionLog(transactionLog), sale, Options{})
+	if err != nil {
+		t.Fatalf("PreviewSale: %s", err.Error())
+	}
+
+	expectedGains := map[string]string{"average": "175.00", "fifo": "100.00", "hifo": "250.00", "lifo": "100.00", "specid": "100.00", "uk": "175.00"}
+	if len(previews) != len(LotSelectorNames()) {
+		t.Fatalf("PreviewSale: Expected a preview per algorithm (%d) ... got %d instead", len(LotSelectorNames()), len(previews))
+	}
+	for _, preview := range previews {
+		if preview.Err != nil {
+			t.Errorf("PreviewSale (%s): %s", preview.Algorithm, preview.Err.Error())
+			continue
+		}
+		if preview.Gain().StringFixed(2) != expectedGains[preview.Algorithm] {
+			t.Errorf("PreviewSale (%s): Expected a gain of %s ... got %s instead", preview.Algorithm, expectedGains[preview.Algorithm], preview.Gain().StringFixed(2))
+		}
+		var quantity Decimal
+		for _, disposal := range preview.Disposals {
+			if !disposal.Sold.Equal(sale.Date) {
+				t.Errorf("PreviewSale (%s): Expected only disposals of the proposed sale ... got %s instead", preview.Algorithm, disposal)
+			}
+			quantity = quantity.Add(disposal.Quantity)
+		}
+		if quantity.Cmp(sale.Quantity) != 0 {
+			t.Errorf("PreviewSale (%s): Expected disposals of %s ... got %s instead", preview.Algorithm, sale.Quantity, quantity)
+		}
+	}
+
+	// The transaction log is left as it was
+	lots, disposals, err := ProcessTransactions(transactionLog, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	if len(lots) != 3 || len(disposals) != 1 {
+		t.Errorf("PreviewSale: Expected the transaction log to be unchanged ... got %d lot(s) and %d disposal(s) instead", len(lots), len(disposals))
+	}
+
+	var out bytes.Buffer
+	if err := WriteSalePreviews(&out, previews[1:3], DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteSalePreviews: %s", err.Error())
+	}
+	expected := "fifo,1,2020-01-01,2021-06-01,1.00000000,100.00,250.00,150.00,long,,\n" +
+		"fifo,2,2021-01-01,2021-06-01,1.00000000,300.00,250.00,-50.00,short,,\n" +
+		"fifo,total,100.00\n" +
+		"hifo,3,2021-03-01,2021-06-01,1.00000000,150.00,250.00,100.00,short,,\n" +
+		"hifo,1,2020-01-01,2021-06-01,1.00000000,100.00,250.00,150.00,long,,\n" +
+		"hifo,total,250.00\n"
+	if out.String() != expected {
+		t.Errorf("WriteSalePreviews: Expected:\n%s... got:\n%s instead", expected, out.String())
+	}
+}
+
+func TestPreviewSaleFromAccount(t *testing.T) {
+	transactionLog := []string{
+		"2021-01-01,buy,100.00,1.00000000,BTC,,,,exchange",
+		"2021-02-01,buy,200.00,1.00000000,BTC,,,,wallet",
+	}
+	sale, err := ParseTransaction("2021-06-01,sell,250.00,1.00000000,BTC,,,,wallet")
+	if err != nil {
+		t.Fatalf("ParseTransaction: %s", err.Error())
+	}
+	previews, err := PreviewSale(mustParseTransactionLog(transactionLog), sale, Options{})
+	if err != nil {
+		t.Fatalf("PreviewSale: %s", err.Error())
+	}
+
+	// Only the lots of the account of the sale are consumed, whatever the algorithm
+	var out bytes.Buffer
+	if err := WriteSalePreviews(&out, previews[1:2], DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteSalePreviews: %s", err.Error())
+	}
+	expected := "fifo,1,2021-02-01,2021-06-01,1.00000000,200.00,250.00,50.00,short,BTC,wallet\n" +
+		"fifo,total,50.00\n"
+	if out.String() != expected {
+		t.Errorf("WriteSalePreviews: Expected:\n%s... got:\n%s instead", expected, out.String())
+	}
+}
+
+func TestPreviewSaleProblems(t *testing.T) {
+	transactionLog := []string{"2021-01-01,buy,100.00,1.00000000"}
+
+	// Problems with a single algorithm are reported in its preview
+	sale, _ := ParseTransaction("2021-02-01,sell,50.00,1.00000000")
+	previews, err := PreviewSale(mustParseTransactionLog(transactionLog), sale, Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("PreviewSale: %s", err.Error())
+	}
+	for _, preview := range previews {
+		unsupported := preview.Algorithm == "average" || preview.Algorithm == "uk"
+		if unsupported != (preview.Err != nil) {
+			t.Errorf("PreviewSale (%s): Expected an error only for algorithms without wash sale detection ... got %v instead", preview.Algorithm, preview.Err)
+		}
+	}
+	var out bytes.Buffer
+	if err := WriteSalePreviews(&out, previews[:1], DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteSalePreviews: %s", err.Error())
+	}
+	if expected := "# average: Wash sale detection is not supported by the average algorithm\n"; out.String() != expected {
+		t.Errorf("WriteSalePreviews: Expected %q ... got %q instead", expected, out.String())
+	}
+
+	testCases := []struct {
+		rawSale       string
+		expectedError string
+	}{
+		{"2021-02-01,buy,50.00,1.00000000", "must be a sale"},
+		{"2020-12-31,sell,50.00,1.00000000", "before the last transaction"},
+	}
+	for _, testCase := range testCases {
+		sale, _ := ParseTransaction(testCase.rawSale)
+		if _, err := PreviewSale(mustParseTransactionLog(transactionLog), sale, Options{}); err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+			t.Errorf("PreviewSale (%s): Expected an error containing %q ... got %v instead", testCase.rawSale, testCase.expectedError, err)
+		}
+	}
+}
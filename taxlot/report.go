@@ -0,0 +1,306 @@
+package taxlot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Output formats available for the remaining lots
+const (
+	OutputFormatCSV    = OutputFormat("csv")
+	OutputFormatJSON   = OutputFormat("json")
+	OutputFormatNDJSON = OutputFormat("ndjson")
+)
+
+// An OutputFormat selects how remaining lots are serialized
+type OutputFormat string
+
+func (format OutputFormat) String() string {
+	return string(format)
+}
+
+// Set implements flag.Value, accepting "csv", "json" or "ndjson"
+func (format *OutputFormat) Set(s string) error {
+	switch candidate := OutputFormat(strings.ToLower(s)); candidate {
+	case OutputFormatCSV, OutputFormatJSON, OutputFormatNDJSON:
+		*format = candidate
+		return nil
+	}
+	return fmt.Errorf("Invalid output format (must be one of \"csv\", \"json\" or \"ndjson\"): %s", s)
+}
+
+// JSON representation of a remaining lot (see README for the schema)
+// Prices and quantities are strings holding the full-precision decimal value, so that no precision is lost to floating point
+type lotJSON struct {
+	ID       int    `json:"id"`
+	Date     string `json:"date"`
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+	Symbol   string `json:"symbol,omitempty"`
+	Account  string `json:"account,omitempty"`
+	// Buy fees included in the price, only present if there were any
+	Fee         string `json:"fee,omitempty"`
+	FeeCurrency string `json:"feeCurrency,omitempty"`
+	// Id of the next lot of the asset in the account, only present if it does not follow the highest lot id remaining
+	NextID int `json:"nextId,omitempty"`
+	// Only present for wash sale replacement lots, whose holding period starts before their date
+	HoldingPeriodStart string `json:"holdingPeriodStart,omitempty"`
+}
+
+func newLotJSON(lot Lot) lotJSON {
+	object := lotJSON{
+		ID:          lot.ID,
+		Date:        lot.Date.Format(DateLayout),
+		Price:       lot.Price.String(),
+		Quantity:    lot.Quantity.String(),
+		Symbol:      lot.Symbol,
+		Account:     lot.Account,
+		FeeCurrency: lot.FeeCurrency,
+		NextID:      lot.NextID,
+	}
+	if !lot.Fee.IsZero() {
+		object.Fee = lot.Fee.String()
+	}
+	if !lot.HoldingStart.IsZero() {
+		object.HoldingPeriodStart = lot.HoldingStart.Format(DateLayout)
+	}
+	return object
+}
+
+// Function to write the remaining lots to out in the given format
+// csv writes one Lot.String per line, json writes a single array of lot objects, and ndjson writes one lot object per line
+func WriteLots(out io.Writer, lots []Lot, format OutputFormat) error {
+	switch format {
+	case OutputFormatJSON:
+		objects := make([]lotJSON, 0, len(lots))
+		for _, lot := range lots {
+			objects = append(objects, newLotJSON(lot))
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(objects)
+	case OutputFormatNDJSON:
+		encoder := json.NewEncoder(out)
+		for _, lot := range lots {
+			if err := encoder.Encode(newLotJSON(lot)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		for _, lot := range lots {
+			if _, err := fmt.Fprintf(out, "%s\n", lot.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Function to parse remaining lots read from in, in any of the formats written by WriteLots: one Lot.String per line
+// (csv, skipping blank and comment lines), a JSON array of lot objects (json), or one lot object per line (ndjson)
+// Lots keep their ids, dates and holding periods; as csv rounds prices to cents and leaves out fees and the next lot id,
+// json keeps the exact basis of every lot, and lots bought later are numbered without reusing the ids of lots sold off
+func ParseLots(in io.Reader) ([]Lot, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '[' && trimmed[0] != '{') {
+		return parseLotsCSV(bytes.NewReader(data))
+	}
+
+	var objects []lotJSON
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if trimmed[0] == '[' {
+		if err := decoder.Decode(&objects); err != nil {
+			return nil, fmt.Errorf("Problem parsing lots: %s", err.Error())
+		}
+	} else {
+		for decoder.More() {
+			var object lotJSON
+			if err := decoder.Decode(&object); err != nil {
+				return nil, fmt.Errorf("Problem parsing lot %d: %s", len(objects)+1, err.Error())
+			}
+			objects = append(objects, object)
+		}
+	}
+	lots := make([]Lot, 0, len(objects))
+	for idx, object := range objects {
+		lot, err := object.lot()
+		if err != nil {
+			return nil, fmt.Errorf("Problem parsing lot %d: %s", idx+1, err.Error())
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+// Function to parse lots in csv format, one Lot.String per line
+func parseLotsCSV(in io.Reader) ([]Lot, error) {
+	var lots []Lot
+	scanner := NewTransactionScanner(in)
+	for scanner.Scan() {
+		lotArray := strings.Split(scanner.Text(), ",")
+		if len(lotArray) < 4 || len(lotArray) > 6 {
+			return nil, fmt.Errorf("Problem parsing lot on line %d (%s): Invalid lot format; incorrect argument count (should be between 4 and 6, got %d)", scanner.Line(), scanner.Text(), len(lotArray))
+		}
+		object := lotJSON{Date: lotArray[1], Price: lotArray[2], Quantity: lotArray[3]}
+		if len(lotArray) > 4 {
+			object.Symbol = lotArray[4]
+		}
+		if len(lotArray) > 5 {
+			object.Account = lotArray[5]
+		}
+		// Ids that are not integers are left at zero, which is rejected as an invalid lot id
+		object.ID, _ = strconv.Atoi(lotArray[0])
+		lot, err := object.lot()
+		if err != nil {
+			return nil, fmt.Errorf("Problem parsing lot on line %d (%s): %s", scanner.Line(), scanner.Text(), err.Error())
+		}
+		lots = append(lots, lot)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+// Function to convert a lot object back into a Lot, making sure that it describes a valid open lot
+func (object lotJSON) lot() (Lot, error) {
+	if object.ID < 1 {
+		return Lot{}, fmt.Errorf("Invalid lot id (must be a positive integer)")
+	}
+	date, err := ParseDate(object.Date)
+	if err != nil {
+		return Lot{}, err
+	}
+	price, err := ParseDecimal(object.Price)
+	if err != nil || price.Sign() < 0 {
+		return Lot{}, fmt.Errorf("Invalid price (must be a non-negative number): %s", object.Price)
+	}
+	quantity, err := ParseDecimal(object.Quantity)
+	if err != nil || quantity.Sign() <= 0 {
+		return Lot{}, fmt.Errorf("Invalid quantity (must be a positive number): %s", object.Quantity)
+	}
+	lot := Lot{ID: object.ID, Date: date, Price: price, Quantity: quantity, Symbol: strings.ToUpper(object.Symbol), Account: object.Account, FeeCurrency: object.FeeCurrency, NextID: object.NextID}
+	if object.Fee != "" {
+		if lot.Fee, err = ParseDecimal(object.Fee); err != nil || lot.Fee.Sign() < 0 {
+			return Lot{}, fmt.Errorf("Invalid fee (must be a non-negative number): %s", object.Fee)
+		}
+	}
+	if object.NextID != 0 && object.NextID <= object.ID {
+		return Lot{}, fmt.Errorf("Invalid next lot id (must be greater than the lot id): %d", object.NextID)
+	}
+	if object.HoldingPeriodStart != "" {
+		if lot.HoldingStart, err = ParseDate(object.HoldingPeriodStart); err != nil {
+			return Lot{}, err
+		}
+		// Only wash sale replacement lots have a holding period starting before their date, and they may not replace again
+		lot.washReplacement = true
+	}
+	return lot, nil
+}
+
+// Realized gains totals of every disposal in a single tax year with the same holding period classification
+type TaxYearSummary struct {
+	Year      int
+	Term      string
+	Quantity  Decimal
+	CostBasis Decimal
+	Proceeds  Decimal
+	// Losses disallowed by the wash sale rule, which are excluded from the realized gain
+	DisallowedLoss Decimal
+}
+
+// Realized gain of all disposals in the summary; negative values are losses
+func (summary TaxYearSummary) Gain() Decimal {
+	return summary.Proceeds.Sub(summary.CostBasis).Add(summary.DisallowedLoss)
+}
+
+func (summary TaxYearSummary) String() string {
+	return fmt.Sprintf("%d,%s,%s,%s,%s,%s,%s", summary.Year, summary.Term, summary.Quantity.StringFixed(8), summary.CostBasis.StringFixed(2), summary.Proceeds.StringFixed(2), summary.Gain().StringFixed(2), summary.DisallowedLoss.StringFixed(2))
+}
+
+// Function to total realized gains per tax year (the calendar year of the sale) and holding period classification
+// Returns summaries ordered by year, with short-term totals before long-term totals
+func SummarizeByTaxYear(disposals []Disposal, longTermThreshold HoldingPeriod) []TaxYearSummary {
+	summarizer := NewTaxYearSummarizer(longTermThreshold)
+	for _, disposal := range disposals {
+		summarizer.Add(disposal)
+	}
+	return summarizer.Summaries()
+}
+
+// A TaxYearSummarizer totals realized gains per tax year and holding period one disposal at a time, so that the
+// disposals themselves need not be kept (see ProcessStream)
+type TaxYearSummarizer struct {
+	longTermThreshold HoldingPeriod
+	summaries         []TaxYearSummary
+}
+
+// Function to create an empty TaxYearSummarizer, classifying disposals with the given long-term threshold
+func NewTaxYearSummarizer(longTermThreshold HoldingPeriod) *TaxYearSummarizer {
+	return &TaxYearSummarizer{longTermThreshold: longTermThreshold}
+}
+
+// Function to add disposal to the totals of its tax year and holding period classification
+func (summarizer *TaxYearSummarizer) Add(disposal Disposal) {
+	summaries := summarizer.summaries
+	year, term := disposal.Sold.Year(), disposal.Term(summarizer.longTermThreshold)
+	idx := 0
+	for idx < len(summaries) && (summaries[idx].Year != year || summaries[idx].Term != term) {
+		idx++
+	}
+	if idx == len(summaries) {
+		summaries = append(summaries, TaxYearSummary{Year: year, Term: term})
+		summarizer.summaries = summaries
+	}
+	summaries[idx].Quantity = summaries[idx].Quantity.Add(disposal.Quantity)
+	summaries[idx].CostBasis = summaries[idx].CostBasis.Add(disposal.CostBasis)
+	summaries[idx].Proceeds = summaries[idx].Proceeds.Add(disposal.Proceeds)
+	summaries[idx].DisallowedLoss = summaries[idx].DisallowedLoss.Add(disposal.DisallowedLoss)
+}
+
+// Function to list the totals of every disposal added so far, ordered by year with short-term totals before long-term totals
+func (summarizer *TaxYearSummarizer) Summaries() []TaxYearSummary {
+	summaries := append([]TaxYearSummary(nil), summarizer.summaries...)
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].Year != summaries[j].Year {
+			return summaries[i].Year < summaries[j].Year
+		}
+		return summaries[i].Term == ShortTerm && summaries[j].Term == LongTerm
+	})
+	return summaries
+}
+
+// Function to write realized gains to out, one disposal per line in the format of
+// lotId,acquiredDate,soldDate,quantity,costBasis,proceeds,gain,term,symbol,acquisitionFee,saleFee,feeCurrency,unitCost,rule,disallowedLoss,account
+// where costBasis includes acquisitionFee, proceeds are net of saleFee, gain excludes disallowedLoss (wash sales),
+// and symbol, feeCurrency, rule and account may be empty
+func WriteDisposals(out io.Writer, disposals []Disposal, longTermThreshold HoldingPeriod) error {
+	for _, disposal := range disposals {
+		if _, err := fmt.Fprintf(out, "%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\n", disposal.String(), disposal.Term(longTermThreshold), disposal.Symbol, disposal.AcquisitionFee.StringFixed(2), disposal.SaleFee.StringFixed(2), disposal.FeeCurrency, disposal.UnitCost.StringFixed(2), disposal.Rule, disposal.DisallowedLoss.StringFixed(2), disposal.Account); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to write realized gains totals to out, one line per tax year and holding period in the format of
+// year,term,quantity,costBasis,proceeds,gain,disallowedLoss
+func WriteTaxYearSummary(out io.Writer, summaries []TaxYearSummary) error {
+	for _, summary := range summaries {
+		if _, err := fmt.Fprintf(out, "%s\n", summary.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package taxlot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteDisposals(t *testing.T) {
+	_, disposals, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-02-01,sell,15000.00,1.50000000"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := WriteDisposals(&out, disposals, DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteDisposals: %s", err.Error())
+	}
+	want := "1,2021-01-01,2021-02-01,1.00000000,10000.00,15000.00,5000.00,short,,0.00,0.00,,10000.00,,0.00,\n2,2021-01-02,2021-02-01,0.50000000,10000.00,7500.00,-2500.00,short,,0.00,0.00,,20000.00,,0.00,\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteDisposals: Expected output %q ... got %q instead", want, got)
+	}
+}
+
+func TestSummarizeByTaxYear(t *testing.T) {
+	_, disposals, err := ProcessTransactions([]string{
+		"2020-01-01,buy,10000.00,1.00000000",
+		"2020-06-01,buy,20000.00,1.00000000",
+		"2020-12-01,sell,15000.00,0.25000000",
+		"2021-01-01,sell,30000.00,0.25000000",
+		"2021-01-02,sell,40000.00,1.00000000",
+		"2022-03-01,sell,10000.00,0.50000000",
+	}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := WriteTaxYearSummary(&out, SummarizeByTaxYear(disposals, DefaultLongTermThreshold)); err != nil {
+		t.Fatalf("WriteTaxYearSummary: %s", err.Error())
+	}
+	want := "2020,short,0.25000000,2500.00,3750.00,1250.00,0.00\n" +
+		"2021,short,0.75000000,12500.00,27500.00,15000.00,0.00\n" +
+		"2021,long,0.50000000,5000.00,20000.00,15000.00,0.00\n" +
+		"2022,long,0.50000000,10000.00,5000.00,-5000.00,0.00\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteTaxYearSummary: Expected output %q ... got %q instead", want, got)
+	}
+
+	// With a six month threshold, every disposal in this log becomes long-term
+	out.Reset()
+	if err := WriteTaxYearSummary(&out, SummarizeByTaxYear(disposals, HoldingPeriod{Months: 6})); err != nil {
+		t.Fatalf("WriteTaxYearSummary: %s", err.Error())
+	}
+	want = "2020,long,0.25000000,2500.00,3750.00,1250.00,0.00\n" +
+		"2021,long,1.25000000,17500.00,47500.00,30000.00,0.00\n" +
+		"2022,long,0.50000000,10000.00,5000.00,-5000.00,0.00\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteTaxYearSummary: Expected output %q ... got %q instead", want, got)
+	}
+}
+
+func TestWriteLots(t *testing.T) {
+	lots, _, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-01,buy,15000.00,2.00000000", "2021-01-02,buy,20000.123456789,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	testCases := []struct {
+		format   OutputFormat
+		expected string
+	}{
+		{OutputFormatCSV, "1,2021-01-01,13333.33,1.50000000\n2,2021-01-02,20000.12,1.00000000\n"},
+		{OutputFormatJSON, `[
+  {
+    "id": 1,
+    "date": "2021-01-01",
+    "price": "13333.33333333",
+    "quantity": "1.50000000"
+  },
+  {
+    "id": 2,
+    "date": "2021-01-02",
+    "price": "20000.12345679",
+    "quantity": "1.00000000"
+  }
+]
+`},
+		{OutputFormatNDJSON, `{"id":1,"date":"2021-01-01","price":"13333.33333333","quantity":"1.50000000"}
+{"id":2,"date":"2021-01-02","price":"20000.12345679","quantity":"1.00000000"}
+`},
+	}
+	for _, testCase := range testCases {
+		var out bytes.Buffer
+		if err := WriteLots(&out, lots, testCase.format); err != nil {
+			t.Errorf("WriteLots (%s): %s", testCase.format, err.Error())
+			continue
+		}
+		if got := out.String(); got != testCase.expected {
+			t.Errorf("WriteLots (%s): Expected output %q ... got %q instead", testCase.format, testCase.expected, got)
+		}
+	}
+
+	// An empty result is still a valid JSON document
+	var out bytes.Buffer
+	if err := WriteLots(&out, nil, OutputFormatJSON); err != nil {
+		t.Fatalf("WriteLots: %s", err.Error())
+	}
+	if got := out.String(); got != "[]\n" {
+		t.Errorf("WriteLots: Expected empty JSON array for no lots ... got %q instead", got)
+	}
+}
+
+func TestParseLots(t *testing.T) {
+	lots, _, err := ProcessTransactionsWithOptions([]string{
+		"2021-01-01,buy,100.123,10.00000000",
+		"2021-01-02,buy,50.00,1.00000000,eth,0.25,USD",
+		"2021-01-05,buy,10.00,2.00000000,,,,,cold wallet",
+		"2021-03-01,buy,60.00,5.00000000",
+		"2021-03-10,sell,80.00,10.00000000",
+	}, "fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+
+	// Every format written by WriteLots is parsed back into the same lots (csv only keeps prices to the cent)
+	for _, format := range []OutputFormat{OutputFormatCSV, OutputFormatJSON, OutputFormatNDJSON} {
+		var out bytes.Buffer
+		if err := WriteLots(&out, lots, format); err != nil {
+			t.Fatalf("WriteLots (%s): %s", format, err.Error())
+		}
+		parsed, err := ParseLots(&out)
+		if err != nil {
+			t.Fatalf("ParseLots (%s): %s", format, err.Error())
+		}
+		if len(parsed) != len(lots) {
+			t.Fatalf("ParseLots (%s): Expected %d lots back, got %v instead", format, len(lots), parsed)
+		}
+		for idx, lot := range lots {
+			if format == OutputFormatCSV {
+				lot.Price = mustParseDecimal(lot.Price.StringFixed(2))
+				lot.HoldingStart, lot.washReplacement = time.Time{}, false
+				lot.Fee, lot.FeeCurrency, lot.NextID = Decimal{}, "", 0
+			}
+			if !reflect.DeepEqual(parsed[idx], lot) {
+				t.Errorf("ParseLots (%s): Expected parsed[%d] to be %+v ... got %+v instead", format, idx, lot, parsed[idx])
+			}
+		}
+	}
+
+	testCases := []struct {
+		input                string
+		expectedErrorSnippet string
+	}{
+		{"# opening lots\n1,2021-01-01,100.00\n", "Problem parsing lot on line 2 (1,2021-01-01,100.00): Invalid lot format; incorrect argument count (should be between 4 and 6, got 3)"},
+		{"x,2021-01-01,100.00,1.00000000", "Invalid lot id (must be a positive integer)"},
+		{"1,2021-01-01,100.00,0", "Invalid quantity (must be a positive number): 0"},
+		{"1,2021-01-01,-1,1.00000000", "Invalid price (must be a non-negative number): -1"},
+		{`[{"id":1,"date":"2021-13-01","price":"1","quantity":"1"}]`, "Problem parsing lot 1: Invalid date"},
+		{`[{"id":1,"date":"2021-01-01","price":"1","quantity":"1","fee":"-1"}]`, "Problem parsing lot 1: Invalid fee (must be a non-negative number): -1"},
+		{`[{"id":2,"date":"2021-01-01","price":"1","quantity":"1","nextId":2}]`, "Problem parsing lot 1: Invalid next lot id (must be greater than the lot id): 2"},
+		{`{"id":1,"date":"2021-01-01","price":"1","quantity":"1"}` + "\n" + `{"id":2,"cost":"1"}`, "Problem parsing lot 2: json: unknown field \"cost\""},
+	}
+	for _, testCase := range testCases {
+		_, err := ParseLots(strings.NewReader(testCase.input))
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ParseLots: Expected error containing \"%s\" ... got \"%v\" instead", testCase.expectedErrorSnippet, err)
+		}
+	}
+}
+
+func TestOutputFormatFlag(t *testing.T) {
+	var format OutputFormat
+	for _, valid := range []string{"csv", "JSON", "ndjson"} {
+		if err := format.Set(valid); err != nil {
+			t.Errorf("OutputFormat.Set(%q): Unexpected error: %s", valid, err.Error())
+		}
+	}
+	if err := format.Set("xml"); err == nil {
+		t.Errorf("OutputFormat.Set(\"xml\"): Expected an error, but none resulted")
+	}
+}
+
+func TestWriteMultiAssetReports(t *testing.T) {
+	lots, disposals, err := ProcessTransactions([]string{"2021-01-01,buy,30000.00,1.00000000,BTC", "2021-01-01,buy,1000.00,10.00000000,ETH", "2021-02-01,sell,3000.00,5.00000000,ETH"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := WriteDisposals(&out, disposals, DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteDisposals: %s", err.Error())
+	}
+	want := "1,2021-01-01,2021-02-01,5.00000000,5000.00,15000.00,10000.00,short,ETH,0.00,0.00,,1000.00,,0.00,\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteDisposals: Expected output %q ... got %q instead", want, got)
+	}
+
+	out.Reset()
+	if err := WriteLots(&out, lots, OutputFormatNDJSON); err != nil {
+		t.Fatalf("WriteLots: %s", err.Error())
+	}
+	want = `{"id":1,"date":"2021-01-01","price":"30000.00000000","quantity":"1.00000000","symbol":"BTC"}
+{"id":1,"date":"2021-01-01","price":"1000.00000000","quantity":"5.00000000","symbol":"ETH"}
+`
+	if got := out.String(); got != want {
+		t.Errorf("WriteLots: Expected output %q ... got %q instead", want, got)
+	}
+}
+
+func TestWriteDisposalsWithFees(t *testing.T) {
+	_, disposals, err := ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000,BTC,10.00,USD", "2021-01-02,buy,20000.00,1.00000000,BTC", "2021-02-01,sell,15000.00,1.50000000,BTC,3.00,USD"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := WriteDisposals(&out, disposals, DefaultLongTermThreshold); err != nil {
+		t.Fatalf("WriteDisposals: %s", err.Error())
+	}
+	want := "1,2021-01-01,2021-02-01,1.00000000,10010.00,14998.00,4988.00,short,BTC,10.00,2.00,USD,10010.00,,0.00,\n" +
+		"2,2021-01-02,2021-02-01,0.50000000,10000.00,7499.00,-2501.00,short,BTC,0.00,1.00,USD,20000.00,,0.00,\n"
+	if got := out.String(); got != want {
+		t.Errorf("WriteDisposals: Expected output %q ... got %q instead", want, got)
+	}
+}
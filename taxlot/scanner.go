@@ -0,0 +1,67 @@
+package taxlot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Maximum length of a line of a transaction log, in bytes
+const maxLineLength = bufio.MaxScanTokenSize
+
+// A TransactionScanner reads a transaction log one raw transaction (line) at a time, keeping track of line numbers
+// Blank lines and comment lines (whose first non-blank character is #) are skipped
+type TransactionScanner struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// Function to create a TransactionScanner reading the transaction log from in
+func NewTransactionScanner(in io.Reader) *TransactionScanner {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxLineLength)
+	return &TransactionScanner{scanner: scanner}
+}
+
+// Function to advance to the next raw transaction, which is then available through Text
+// Returns false at the end of the log, or if reading failed (see Err)
+func (scanner *TransactionScanner) Scan() bool {
+	for scanner.scanner.Scan() {
+		scanner.line++
+		if !isSkippedLine(scanner.scanner.Text()) {
+			return true
+		}
+	}
+	return false
+}
+
+// The raw transaction read by the most recent call to Scan
+func (scanner *TransactionScanner) Text() string {
+	return scanner.scanner.Text()
+}
+
+// Line number (starting from 1) of the raw transaction read by the most recent call to Scan
+func (scanner *TransactionScanner) Line() int {
+	return scanner.line
+}
+
+// Function to report the first error encountered while reading the transaction log, naming the line it occurred on
+// Returns nil if the whole log was read
+func (scanner *TransactionScanner) Err() error {
+	err := scanner.scanner.Err()
+	if err == bufio.ErrTooLong {
+		return fmt.Errorf("Line %d of the transaction log is longer than the maximum of %d bytes", scanner.line+1, maxLineLength)
+	}
+	if err != nil {
+		return fmt.Errorf("Problem reading line %d of the transaction log: %s", scanner.line+1, err.Error())
+	}
+	return nil
+}
+
+// Function to determine whether a line of a transaction log holds no transaction, being either blank (or whitespace only)
+// or a comment whose first non-blank character is #
+func isSkippedLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || trimmed[0] == '#'
+}
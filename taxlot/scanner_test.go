@@ -0,0 +1,53 @@
+package taxlot
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTransactionScanner(t *testing.T) {
+	input := "# acquisitions\n2021-01-01,buy,10000.00,1.00000000\n\n   \n  # disposals\n2021-02-01,sell,20000.00,0.50000000\n\n"
+	expected := []struct {
+		text string
+		line int
+	}{
+		{"2021-01-01,buy,10000.00,1.00000000", 2},
+		{"2021-02-01,sell,20000.00,0.50000000", 6},
+	}
+	scanner := NewTransactionScanner(strings.NewReader(input))
+	for _, want := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("TransactionScanner.Scan: Expected line %d to be read ... got the end of the log instead (error: %v)", want.line, scanner.Err())
+		}
+		if scanner.Text() != want.text || scanner.Line() != want.line {
+			t.Errorf("TransactionScanner: Expected \"%s\" on line %d ... got \"%s\" on line %d instead", want.text, want.line, scanner.Text(), scanner.Line())
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("TransactionScanner.Scan: Expected the end of the log ... got \"%s\" on line %d instead", scanner.Text(), scanner.Line())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Errorf("TransactionScanner.Err: Expected no error ... got %s instead", err.Error())
+	}
+}
+
+func TestTransactionScannerErrors(t *testing.T) {
+	testCases := []struct {
+		in                   io.Reader
+		expectedErrorSnippet string
+	}{
+		{strings.NewReader("2021-01-01,buy,10000.00,1.00000000\n\n" + strings.Repeat("1", maxLineLength+1)), "Line 3 of the transaction log is longer than the maximum of 65536 bytes"},
+		{io.MultiReader(strings.NewReader("2021-01-01,buy,10000.00,1.00000000\n"), iotest.ErrReader(errors.New("disk failure"))), "Problem reading line 2 of the transaction log: disk failure"},
+	}
+	for _, testCase := range testCases {
+		scanner := NewTransactionScanner(testCase.in)
+		for scanner.Scan() {
+		}
+		if err := scanner.Err(); err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("TransactionScanner.Err: Expected error containing \"%s\" ... got \"%v\" instead", testCase.expectedErrorSnippet, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package taxlot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LotSelector is a tax lot selection algorithm, which decides which existing lots a sale consumes first
+type LotSelector interface {
+	// Prioritize sorts lots in place such that the lots to be sold first are at the head of the slice
+	Prioritize(lots []Lot)
+}
+
+// Registry of available lot selection algorithms, keyed by the name passed in on the command-line
+var lotSelectors = map[string]LotSelector{}
+
+func init() {
+	RegisterLotSelector("fifo", fifoSelector{})
+	RegisterLotSelector("hifo", hifoSelector{})
+	RegisterLotSelector("lifo", lifoSelector{})
+	RegisterLotSelector("specid", specIDSelector{fallback: fifoSelector{}})
+	RegisterLotSelector("average", averageSelector{})
+	RegisterLotSelector("uk", ukSelector{})
+}
+
+// Function to add a lot selection algorithm to the registry under the given name
+// Registering the same name twice is a programming error and panics
+func RegisterLotSelector(name string, selector LotSelector) {
+	if _, exists := lotSelectors[name]; exists {
+		panic(fmt.Sprintf("lot selector already registered: %s", name))
+	}
+	lotSelectors[name] = selector
+}
+
+// Function to look up a registered lot selection algorithm by name
+// Specific identification takes its fallback algorithm after a colon, e.g. "specid:hifo"
+func LookupLotSelector(name string) (LotSelector, error) {
+	if colon := strings.IndexByte(name, ':'); colon >= 0 && name[:colon] == "specid" {
+		fallback, ok := lotSelectors[name[colon+1:]]
+		// The fallback only orders individual lots, so it cannot be specid itself, average (which pools lots on buying)
+		// or uk (which matches sales by its own identification rules)
+		switch fallback.(type) {
+		case specIDSelector, averageSelector, ukSelector:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid fallback algorithm for specid: %s", name[colon+1:])
+		}
+		return specIDSelector{fallback: fallback}, nil
+	}
+	selector, ok := lotSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("Invalid algorithm (must be one of %s): %s", DescribeLotSelectors(), name)
+	}
+	return selector, nil
+}
+
+// Function to list the names of all registered lot selection algorithms, in alphabetical order
+func LotSelectorNames() []string {
+	names := make([]string, 0, len(lotSelectors))
+	for name := range lotSelectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function to describe the registered algorithms in human-readable form, e.g. "fifo", "hifo" or "lifo"
+func DescribeLotSelectors() string {
+	names := LotSelectorNames()
+	quoted := make([]string, len(names))
+	for idx, name := range names {
+		quoted[idx] = fmt.Sprintf("%q", name)
+	}
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// First-in-first-out: the first lots bought are the first lots sold
+type fifoSelector struct{}
+
+func (fifoSelector) Prioritize(lots []Lot) {
+	// Lots are kept in chronological order, so there is nothing to do
+}
+
+// Highest-in-first-out: the first lots sold are the lots with the highest price
+type hifoSelector struct{}
+
+func (hifoSelector) Prioritize(lots []Lot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].Price.Cmp(lots[j].Price) > 0
+	})
+}
+
+// Last-in-first-out: the last lots bought are the first lots sold
+type lifoSelector struct{}
+
+func (lifoSelector) Prioritize(lots []Lot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		if !lots[i].Date.Equal(lots[j].Date) {
+			return lots[i].Date.After(lots[j].Date)
+		}
+		return lots[i].ID > lots[j].ID
+	})
+}
+
+// Specific identification: sales consume the lots they designate (see takeDesignated)
+// Any undesignated remainder of a sale is sold according to the fallback algorithm, which defaults to fifo
+type specIDSelector struct {
+	fallback LotSelector
+}
+
+func (selector specIDSelector) Prioritize(lots []Lot) {
+	selector.fallback.Prioritize(lots)
+}
+
+// Average cost: every buy of an asset is merged into a single pooled lot at the weighted-average price (see Book.Buy),
+// so sales reduce the pool's quantity at its current average cost without changing it
+type averageSelector struct{}
+
+func (averageSelector) Prioritize(lots []Lot) {
+	// There is only ever one (pooled) lot, so there is nothing to do
+}
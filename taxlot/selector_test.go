@@ -0,0 +1,231 @@
+package taxlot
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Test-only selector that sells the lowest-priced lots first
+type lowestPriceSelector struct{}
+
+func (lowestPriceSelector) Prioritize(lots []Lot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].Price.Cmp(lots[j].Price) < 0
+	})
+}
+
+func TestLotSelectorRegistry(t *testing.T) {
+	expectedNames := []string{"average", "fifo", "hifo", "lifo", "specid", "uk"}
+	if names := LotSelectorNames(); !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("LotSelectorNames: Expected %v ... got %v instead", expectedNames, names)
+	}
+
+	expectedDescription := "\"average\", \"fifo\", \"hifo\", \"lifo\", \"specid\" or \"uk\""
+	if description := DescribeLotSelectors(); description != expectedDescription {
+		t.Errorf("DescribeLotSelectors: Expected %s ... got %s instead", expectedDescription, description)
+	}
+
+	for _, name := range expectedNames {
+		if _, err := LookupLotSelector(name); err != nil {
+			t.Errorf("LookupLotSelector: Unexpected error for registered algorithm %s: %s", name, err.Error())
+		}
+	}
+
+	_, err := LookupLotSelector("lofi")
+	if err == nil {
+		t.Fatalf("LookupLotSelector: Unregistered algorithm didn't elicit an error")
+	}
+	expectedErrorMessage := "Invalid algorithm (must be one of \"average\", \"fifo\", \"hifo\", \"lifo\", \"specid\" or \"uk\"): lofi"
+	if err.Error() != expectedErrorMessage {
+		t.Errorf("LookupLotSelector: Expected error \"%s\" ... got \"%s\" instead", expectedErrorMessage, err.Error())
+	}
+}
+
+func TestRegisteredCustomSelector(t *testing.T) {
+	RegisterLotSelector("lowest", lowestPriceSelector{})
+	defer delete(lotSelectors, "lowest")
+
+	if description := DescribeLotSelectors(); !strings.Contains(description, "\"lowest\"") {
+		t.Errorf("DescribeLotSelectors: Expected custom algorithm to be listed ... got %s instead", description)
+	}
+
+	resultingLots, _, err := ProcessTransactions([]string{"2021-01-01,buy,20000.00,1.00000000", "2021-01-02,buy,10000.00,1.00000000", "2021-01-03,buy,30000.00,1.00000000", "2021-02-01,sell,20000.00,1.50000000"}, "lowest")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	expected := []string{"1,2021-01-01,20000.00,0.50000000", "3,2021-01-03,30000.00,1.00000000"}
+	if len(resultingLots) != len(expected) {
+		t.Fatalf("ProcessTransactions: Expected %d resulting lots back, got %d instead", len(expected), len(resultingLots))
+	}
+	for idx, want := range expected {
+		if got := resultingLots[idx].String(); got != want {
+			t.Errorf("ProcessTransactions: Expected resultingLots[%d].String() to be %s ... got %s instead", idx, want, got)
+		}
+	}
+}
+
+func TestDuplicateSelectorRegistrationPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterLotSelector: Registering \"fifo\" twice didn't panic")
+		}
+	}()
+	RegisterLotSelector("fifo", fifoSelector{})
+}
+
+func TestSpecificIdentification(t *testing.T) {
+	buys := []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000", "2021-01-03,buy,30000.00,1.00000000"}
+	testCases := []struct {
+		name              string
+		algorithm         string
+		sale              string
+		expectedLots      []string
+		expectedDisposals []string
+	}{
+		{
+			name:              "designated lot with quantity",
+			algorithm:         "specid",
+			sale:              "2021-02-01,sell,40000.00,0.50000000,,,,2:0.5",
+			expectedLots:      []string{"1,2021-01-01,10000.00,1.00000000", "2,2021-01-02,20000.00,0.50000000", "3,2021-01-03,30000.00,1.00000000"},
+			expectedDisposals: []string{"2,2021-01-02,2021-02-01,0.50000000,10000.00,20000.00,10000.00"},
+		},
+		{
+			name:              "multiple designated lots, in designation order",
+			algorithm:         "specid",
+			sale:              "2021-02-01,sell,40000.00,1.50000000,,,,3;1:0.5",
+			expectedLots:      []string{"1,2021-01-01,10000.00,0.50000000", "2,2021-01-02,20000.00,1.00000000"},
+			expectedDisposals: []string{"3,2021-01-03,2021-02-01,1.00000000,30000.00,40000.00,10000.00", "1,2021-01-01,2021-02-01,0.50000000,5000.00,20000.00,15000.00"},
+		},
+		{
+			name:              "undesignated remainder falls back to fifo",
+			algorithm:         "specid",
+			sale:              "2021-02-01,sell,40000.00,1.50000000,,,,3:0.5",
+			expectedLots:      []string{"2,2021-01-02,20000.00,1.00000000", "3,2021-01-03,30000.00,0.50000000"},
+			expectedDisposals: []string{"3,2021-01-03,2021-02-01,0.50000000,15000.00,20000.00,5000.00", "1,2021-01-01,2021-02-01,1.00000000,10000.00,40000.00,30000.00"},
+		},
+		{
+			name:              "undesignated remainder falls back to the configured algorithm",
+			algorithm:         "specid:hifo",
+			sale:              "2021-02-01,sell,40000.00,1.50000000,,,,1:0.5",
+			expectedLots:      []string{"1,2021-01-01,10000.00,0.50000000", "2,2021-01-02,20000.00,1.00000000"},
+			expectedDisposals: []string{"1,2021-01-01,2021-02-01,0.50000000,5000.00,20000.00,15000.00", "3,2021-01-03,2021-02-01,1.00000000,30000.00,40000.00,10000.00"},
+		},
+		{
+			name:              "sale without designations uses the fallback entirely",
+			algorithm:         "specid:lifo",
+			sale:              "2021-02-01,sell,40000.00,0.50000000",
+			expectedLots:      []string{"1,2021-01-01,10000.00,1.00000000", "2,2021-01-02,20000.00,1.00000000", "3,2021-01-03,30000.00,0.50000000"},
+			expectedDisposals: []string{"3,2021-01-03,2021-02-01,0.50000000,15000.00,20000.00,5000.00"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resultingLots, disposals, err := ProcessTransactions(append(append([]string{}, buys...), testCase.sale), testCase.algorithm)
+		if err != nil {
+			t.Errorf("ProcessTransactions (%s): %s", testCase.name, err.Error())
+			continue
+		}
+		if len(resultingLots) != len(testCase.expectedLots) || len(disposals) != len(testCase.expectedDisposals) {
+			t.Errorf("ProcessTransactions (%s): Expected %d lot(s) and %d disposal(s) back, got %v and %v instead", testCase.name, len(testCase.expectedLots), len(testCase.expectedDisposals), resultingLots, disposals)
+			continue
+		}
+		for idx, want := range testCase.expectedLots {
+			if got := resultingLots[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected resultingLots[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+		for idx, want := range testCase.expectedDisposals {
+			if got := disposals[idx].String(); got != want {
+				t.Errorf("ProcessTransactions (%s): Expected disposals[%d].String() to be %s ... got %s instead", testCase.name, idx, want, got)
+			}
+		}
+	}
+}
+
+func TestSpecificIdentificationErrors(t *testing.T) {
+	buys := []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000"}
+	testCases := []struct {
+		name                 string
+		algorithm            string
+		sale                 string
+		expectedErrorSnippet string
+	}{
+		{"missing lot", "specid", "2021-02-01,sell,40000.00,0.50000000,,,,7", "Designated lot 7 does not exist (or has already been sold)"},
+		{"insufficient quantity", "specid", "2021-02-01,sell,40000.00,2.00000000,,,,1:1.5", "Designated lot 1 has only 1.00000000 remaining, which is less than the designated 1.50000000"},
+		{"designations exceed sale", "specid", "2021-02-01,sell,40000.00,0.50000000,,,,1", "Designated lot quantities exceed the sale quantity of 0.50000000"},
+		{"designations without specid", "fifo", "2021-02-01,sell,40000.00,0.50000000,,,,1", "Sale designates lots, which requires the \"specid\" algorithm"},
+		{"malformed lot id", "specid", "2021-02-01,sell,40000.00,0.50000000,,,,first", "Invalid lot designation (lot id must be a positive integer): first"},
+		{"malformed quantity", "specid", "2021-02-01,sell,40000.00,0.50000000,,,,1:-1", "Invalid lot designation (quantity must be a positive number): 1:-1"},
+		{"designation on a buy", "specid", "2021-02-01,buy,40000.00,0.50000000,,,,1", "Invalid lot designation (only sell and transfer transactions may designate lots): 1"},
+		{"invalid fallback", "specid:specid", "2021-02-01,sell,40000.00,0.50000000", "Invalid fallback algorithm for specid: specid"},
+		{"pooled fallback", "specid:average", "2021-02-01,sell,40000.00,0.50000000", "Invalid fallback algorithm for specid: average"},
+		{"share pooling fallback", "specid:uk", "2021-02-01,sell,40000.00,0.50000000", "Invalid fallback algorithm for specid: uk"},
+		{"unknown fallback", "specid:lofi", "2021-02-01,sell,40000.00,0.50000000", "Invalid fallback algorithm for specid: lofi"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactions(append(append([]string{}, buys...), testCase.sale), testCase.algorithm)
+		if err == nil {
+			t.Errorf("ProcessTransactions (%s): Expected an error, but none resulted", testCase.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactions (%s): Expected error containing \"%s\" ... got \"%s\" instead", testCase.name, testCase.expectedErrorSnippet, err.Error())
+		}
+	}
+}
+
+func TestAverageCost(t *testing.T) {
+	resultingLots, disposals, err := ProcessTransactions([]string{
+		"2021-01-01,buy,10000.00,1.00000000",
+		"2021-01-02,buy,20000.00,1.00000000",
+		"2021-01-03,sell,40000.00,0.50000000",
+		"2021-01-04,buy,30000.00,0.50000000",
+		"2021-01-05,sell,40000.00,1.00000000",
+		"2021-01-06,buy,10000.00,2.00000000,,20.00",
+	}, "average")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	// Every buy is pooled into lot 1; sales leave the average cost unchanged
+	expectedLots := []string{"1,2021-01-01,12923.33,3.00000000"}
+	if len(resultingLots) != len(expectedLots) {
+		t.Fatalf("ProcessTransactions: Expected %d pooled lot back, got %v instead", len(expectedLots), resultingLots)
+	}
+	for idx, want := range expectedLots {
+		if got := resultingLots[idx].String(); got != want {
+			t.Errorf("ProcessTransactions: Expected resultingLots[%d].String() to be %s ... got %s instead", idx, want, got)
+		}
+	}
+
+	expectedDisposals := []struct {
+		formatted string
+		UnitCost  Decimal
+	}{
+		{"1,2021-01-01,2021-01-03,0.50000000,7500.00,20000.00,12500.00", mustParseDecimal("15000")},
+		{"1,2021-01-01,2021-01-05,1.00000000,18750.00,40000.00,21250.00", mustParseDecimal("18750")},
+	}
+	if len(disposals) != len(expectedDisposals) {
+		t.Fatalf("ProcessTransactions: Expected %d disposals back, got %v instead", len(expectedDisposals), disposals)
+	}
+	for idx, want := range expectedDisposals {
+		if got := disposals[idx].String(); got != want.formatted {
+			t.Errorf("ProcessTransactions: Expected disposals[%d].String() to be %s ... got %s instead", idx, want.formatted, got)
+		}
+		if disposals[idx].UnitCost.Cmp(want.UnitCost) != 0 {
+			t.Errorf("ProcessTransactions: Expected disposals[%d] unit cost to be %s ... got %s instead", idx, want.UnitCost, disposals[idx].UnitCost)
+		}
+	}
+
+	// Once the pool is sold off entirely, the next buy starts a new pool
+	resultingLots, _, err = ProcessTransactions([]string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,sell,20000.00,1.00000000", "2021-01-03,buy,30000.00,1.00000000"}, "average")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	want := "2,2021-01-03,30000.00,1.00000000"
+	if len(resultingLots) != 1 || resultingLots[0].String() != want {
+		t.Errorf("ProcessTransactions: Expected a single new pool %s ... got %v instead", want, resultingLots)
+	}
+}
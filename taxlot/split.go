@@ -0,0 +1,85 @@
+package taxlot
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// A SplitRatio is the number of units of an asset held after a split for every number of units held before it, e.g. 2:1
+// for a 2-for-1 split or 1:10 for a 1-for-10 reverse split
+type SplitRatio struct {
+	After  Decimal
+	Before Decimal
+}
+
+// Ratios are formatted as after:before
+func (ratio SplitRatio) String() string {
+	return fmt.Sprintf("%s:%s", ratio.After, ratio.Before)
+}
+
+// Function to parse a split ratio in the format of after:before (e.g. "2:1" or "1:10"), or a single number of units held
+// after the split for every unit held before it (e.g. "2" or "1.5")
+func parseSplitRatio(rawRatio string) (SplitRatio, error) {
+	rawAfter, rawBefore := rawRatio, "1"
+	if colon := strings.IndexByte(rawRatio, ':'); colon >= 0 {
+		rawAfter, rawBefore = rawRatio[:colon], rawRatio[colon+1:]
+	}
+	after, err := ParseDecimal(rawAfter)
+	if err != nil || after.Sign() <= 0 {
+		return SplitRatio{}, fmt.Errorf("Invalid split ratio (must be positive numbers in the format of after:before, e.g. 2:1 or 1:10): %s", rawRatio)
+	}
+	before, err := ParseDecimal(rawBefore)
+	if err != nil || before.Sign() <= 0 {
+		return SplitRatio{}, fmt.Errorf("Invalid split ratio (must be positive numbers in the format of after:before, e.g. 2:1 or 1:10): %s", rawRatio)
+	}
+	return SplitRatio{After: after, Before: before}, nil
+}
+
+// Function to apply a split of the book's asset, rescaling the quantity and price of every open lot by the split's ratio;
+// lots keep their ids, dates and holding periods, and their cost basis (up to rounding of the price)
+// If the split pays cash in lieu of fractional units (it has a non-zero price per unit after the split), the fractional
+// part of the quantity held after the split is sold at that price, consuming lots in the order decided by selector
+// Returns a Disposal record for every (possibly partial) lot consumed by the cash in lieu; the book is left unchanged if
+// the split fails
+func (book *Book) Split(split Transaction, selector LotSelector) ([]Disposal, error) {
+	splitBook, disposals, err := book.split(split, selector)
+	if err != nil {
+		return nil, err
+	}
+	*book = splitBook
+	return disposals, nil
+}
+
+// Function to apply a split of the book's asset (see Book.Split) to a copy of the book, which is returned along with the
+// disposals of the cash in lieu; the book itself is left unchanged
+func (book *Book) split(split Transaction, selector LotSelector) (Book, []Disposal, error) {
+	ratio := split.SplitRatio
+	splitBook := Book{lots: make([]Lot, len(book.lots)), lotCount: book.lotCount}
+	var held Decimal
+	for idx, lot := range book.lots {
+		quantity := lot.Quantity.proRata(ratio.After, ratio.Before)
+		if quantity.Sign() == 0 {
+			return Book{}, nil, fmt.Errorf("Split ratio of %s would leave nothing of lot %d", ratio, lot.ID)
+		}
+		// The price is derived from the lot's unchanged cost basis, so that it is rounded only once
+		basis := new(big.Int).Mul(lot.Price.bigInt(), lot.Quantity.bigInt())
+		lot.Price = roundQuotient(basis, quantity.bigInt())
+		lot.Quantity = quantity
+		splitBook.lots[idx] = lot
+		held = held.Add(quantity)
+	}
+	fraction := held.fraction()
+	if split.Price.Sign() == 0 || fraction.Sign() == 0 {
+		return splitBook, nil, nil
+	}
+	cashInLieu := split
+	cashInLieu.Type = Sell
+	cashInLieu.Quantity = fraction
+	cashInLieu.SplitRatio = SplitRatio{}
+	disposals, err := splitBook.Sell(cashInLieu, selector)
+	if err != nil {
+		return Book{}, nil, err
+	}
+	return splitBook, disposals, nil
+}
@@ -0,0 +1,206 @@
+package taxlot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSplitRatio(t *testing.T) {
+	testCases := []struct {
+		rawRatio      string
+		expected      SplitRatio
+		expectedError bool
+	}{
+		{"2:1", SplitRatio{After: DecimalFromInt(2), Before: DecimalFromInt(1)}, false},
+		{"1:10", SplitRatio{After: DecimalFromInt(1), Before: DecimalFromInt(10)}, false},
+		{"1.5", SplitRatio{After: mustParseDecimal("1.5"), Before: DecimalFromInt(1)}, false},
+		{"0:1", SplitRatio{}, true},
+		{"2:", SplitRatio{}, true},
+		{"x", SplitRatio{}, true},
+	}
+	for _, testCase := range testCases {
+		ratio, err := parseSplitRatio(testCase.rawRatio)
+		if (err != nil) != testCase.expectedError {
+			t.Errorf("parseSplitRatio (%s): Expected error to be %t ... got %v instead", testCase.rawRatio, testCase.expectedError, err)
+		}
+		if !reflect.DeepEqual(ratio, testCase.expected) {
+			t.Errorf("parseSplitRatio (%s): Expected %s ... got %s instead", testCase.rawRatio, testCase.expected, ratio)
+		}
+	}
+
+	if _, err := ParseTransaction("2021-01-01,split,0,2:1:1,AAPL"); err == nil || err.(*ColumnError).Column != columnQuantity+1 {
+		t.Errorf("ParseTransaction: Expected an invalid split ratio error in the quantity column ... got %v instead", err)
+	}
+}
+
+func TestSplits(t *testing.T) {
+	testCases := []struct {
+		algorithm         string
+		transactions      []string
+		expectedLots      string
+		expectedDisposals string
+	}{
+		// A 2-for-1 split halves prices, keeping ids, dates and cost bases, and later sales use the new quantities
+		{"fifo", []string{
+			"2020-01-01,buy,100.00,3.00000000,AAPL",
+			"2020-06-01,buy,90.00,2.00000000,AAPL",
+			"2021-01-01,buy,10.00,1.00000000,MSFT",
+			"2021-01-01,split,0,2:1,AAPL",
+			"2021-02-01,sell,60.00,1.00000000,AAPL",
+		}, "1,2020-01-01,50.00,5.00000000,AAPL\n2,2020-06-01,45.00,4.00000000,AAPL\n1,2021-01-01,10.00,1.00000000,MSFT\n",
+			"1,2020-01-01,2021-02-01,1.00000000,50.00,60.00,10.00\n"},
+		// A 1-for-3 reverse split leaves 1.83333333 units, of which the fractional part is paid out in cash (by hifo)
+		{"hifo", []string{
+			"2020-01-01,buy,100.00,3.00000000",
+			"2020-06-01,buy,90.00,2.50000000",
+			"2021-01-01,split,800.00,1:3",
+		}, "1,2020-01-01,300.00,0.16666667\n2,2020-06-01,270.00,0.83333333\n",
+			"1,2020-01-01,2021-01-01,0.83333333,250.00,666.67,416.67\n"},
+		// The pool of the average algorithm is split like any other lot
+		{"average", []string{
+			"2020-01-01,buy,100.00,3.00000000",
+			"2020-06-01,buy,80.00,1.00000000",
+			"2021-01-01,split,0,3:2",
+		}, "1,2020-01-01,63.33,6.00000000\n", ""},
+		// Splits of assets without open lots change nothing
+		{"lifo", []string{
+			"2020-01-01,buy,100.00,1.00000000",
+			"2020-02-01,sell,100.00,1.00000000",
+			"2021-01-01,split,10.00,2:1",
+		}, "", "1,2020-01-01,2020-02-01,1.00000000,100.00,100.00,0.00\n"},
+	}
+	for _, testCase := range testCases {
+		lots, disposals, err := ProcessTransactions(testCase.transactions, testCase.algorithm)
+		if err != nil {
+			t.Fatalf("ProcessTransactions (%s): %s", testCase.algorithm, err.Error())
+		}
+		var lotsOutput, disposalsOutput strings.Builder
+		for _, lot := range lots {
+			lotsOutput.WriteString(lot.String() + "\n")
+		}
+		for _, disposal := range disposals {
+			disposalsOutput.WriteString(disposal.String() + "\n")
+		}
+		if lotsOutput.String() != testCase.expectedLots {
+			t.Errorf("ProcessTransactions (%s): Expected lots:\n%s... got:\n%s instead", testCase.algorithm, testCase.expectedLots, lotsOutput.String())
+		}
+		if disposalsOutput.String() != testCase.expectedDisposals {
+			t.Errorf("ProcessTransactions (%s): Expected disposals:\n%s... got:\n%s instead", testCase.algorithm, testCase.expectedDisposals, disposalsOutput.String())
+		}
+	}
+}
+
+func TestSplitKeepsBasisAndHoldingPeriod(t *testing.T) {
+	lots, _, err := ProcessTransactionsWithOptions([]string{
+		"2020-01-01,buy,100.00,3.00000000",
+		"2020-02-01,sell,50.00,1.00000000",
+		"2020-02-10,split,0,2",
+		"2020-02-15,buy,40.00,4.00000000",
+	}, "fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+	// The unit sold at a loss before the split is replaced by 2 units bought after it
+	expected := []Lot{
+		{ID: 1, Date: mustParseDate("2020-01-01"), Price: DecimalFromInt(50), Quantity: DecimalFromInt(4)},
+		{ID: 2, Date: mustParseDate("2020-02-15"), Price: DecimalFromInt(40), Quantity: DecimalFromInt(2)},
+		{ID: 3, Date: mustParseDate("2020-02-15"), Price: DecimalFromInt(65), Quantity: DecimalFromInt(2), HoldingStart: mustParseDate("2020-01-15"), washReplacement: true},
+	}
+	if len(lots) != len(expected) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %v ... got %v instead", expected, lots)
+	}
+	for idx := range expected {
+		if !reflect.DeepEqual(lots[idx], expected[idx]) {
+			t.Errorf("ProcessTransactionsWithOptions: Expected lot %+v ... got %+v instead", expected[idx], lots[idx])
+		}
+	}
+}
+
+func TestSortSplits(t *testing.T) {
+	transactions := []string{"2021-01-01,buy,100.00,1.00000000", "2021-01-01,split,0,2:1"}
+	for _, order := range []SameDateOrder{SameDateBuysFirst, SameDateSellsFirst, SameDateInputOrder} {
+		sorted, err := SortTransactions(transactions, order)
+		if err != nil {
+			t.Fatalf("SortTransactions (%s): %s", order, err.Error())
+		}
+		// Splits take effect at the start of their date, unless the input order is kept
+		if expectSplitFirst := order != SameDateInputOrder; (sorted[0] == transactions[1]) != expectSplitFirst {
+			t.Errorf("SortTransactions (%s): Expected the split first to be %t ... got %v instead", order, expectSplitFirst, sorted)
+		}
+	}
+}
+
+func TestSplitProblems(t *testing.T) {
+	testCases := []struct {
+		algorithm            string
+		transactions         []string
+		expectedErrorSnippet string
+	}{
+		{"uk", []string{"2020-01-01,buy,100.00,1.00000000", "2021-01-01,split,0,2:1"}, "Splits are not supported by the uk algorithm"},
+		{"fifo", []string{"2020-01-01,buy,100.00,0.00000001", "2021-01-01,split,0,1:10"}, "would leave nothing of lot 1"},
+		{"fifo", []string{"2021-01-01,split,-1,2:1"}, "Invalid price (must not be negative)"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactions(testCase.transactions, testCase.algorithm)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactions (%s): Expected an error containing %q ... got %v instead", testCase.algorithm, testCase.expectedErrorSnippet, err)
+		}
+	}
+
+	problems, err := Validate(strings.NewReader("2020-01-01,buy,100.00,3\n2021-01-01,split,10.00,1:2\n2021-01-02,sell,300.00,1.5\n"), "fifo", Options{}, "")
+	if err != nil {
+		t.Fatalf("Validate: %s", err.Error())
+	}
+	if len(problems) != 1 || problems[0].String() != "line 3, column 4: Sale quantity of 1.50000000 exceeds the 1.00000000 held" {
+		t.Errorf("Validate: Expected the cash in lieu to reduce the quantity held ... got %v instead", problems)
+	}
+}
+
+func TestFailedSplitsLeaveBooksUnchanged(t *testing.T) {
+	book := Book{}
+	for _, rawTx := range []string{"2021-01-01,buy,100.00,1.00000000", "2021-01-02,buy,200.00,0.00000001"} {
+		tx, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		book.Buy(tx, fifoSelector{})
+	}
+	want := book.Lots()
+	split, err := ParseTransaction("2021-02-01,split,0,1:10")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := book.Split(split, fifoSelector{}); err == nil {
+		t.Errorf("Split: Expected an error, but none resulted")
+	}
+	if got := book.Lots(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Split: Expected the book to be left unchanged as %v ... got %v instead", want, got)
+	}
+
+	// A split failing in one account leaves the books of every account, and the pending wash sale losses, unchanged
+	ledger, err := NewLedger("fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("NewLedger: %s", err.Error())
+	}
+	for _, rawTx := range []string{"2020-11-01,buy,200.00,0.00000001,,,,,b", "2021-01-01,buy,100.00,1.00000000,,,,,a", "2021-02-01,sell,50.00,0.50000000,,,,,a"} {
+		tx, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := ledger.Apply(tx); err != nil {
+			t.Fatalf("Apply (%s): %s", rawTx, err.Error())
+		}
+	}
+	wantLots := ledger.Lots()
+	wantPending := append([]pendingWashLoss(nil), ledger.pendingLosses[""]...)
+	if _, err := ledger.Apply(split); err == nil || !strings.Contains(err.Error(), "would leave nothing of lot 1") {
+		t.Errorf("Apply: Expected an error containing %q ... got %v instead", "would leave nothing of lot 1", err)
+	}
+	if got := ledger.Lots(); !reflect.DeepEqual(got, wantLots) {
+		t.Errorf("Apply: Expected the lots to be left unchanged as %v ... got %v instead", wantLots, got)
+	}
+	if got := ledger.pendingLosses[""]; len(wantPending) != 1 || !reflect.DeepEqual(got, wantPending) {
+		t.Errorf("Apply: Expected the pending wash sale losses to be left unchanged as %v ... got %v instead", wantPending, got)
+	}
+}
@@ -0,0 +1,276 @@
+package taxlot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of transaction
+const (
+	Buy      = TransactionType("buy")
+	Sell     = TransactionType("sell")
+	Split    = TransactionType("split")
+	Transfer = TransactionType("transfer")
+)
+
+// A TransactionType says whether a Transaction is a buy, a sale, a split or a transfer
+type TransactionType string
+
+// A Transaction is a single buy, sale, split or transfer of an asset, as read from a line of a transaction log
+type Transaction struct {
+	Date time.Time
+	Type TransactionType
+	// For splits, the price is the cash paid in lieu of fractional units, per unit after the split (zero if fractional
+	// units are kept), and the quantity is unused; for transfers, the price is unused
+	Price    Decimal
+	Quantity Decimal
+	// Asset symbol (in upper case); transactions without a symbol belong to a single unnamed asset
+	Symbol string
+	// Total fee paid for the transaction in the price currency, and an optional label of the currency it was paid in
+	Fee         Decimal
+	FeeCurrency string
+	// For sales under specific identification, the lots (and quantities) the sale consumes first
+	Designations []LotDesignation
+	// For splits, the ratio of units held after the split to units held before it (given in the quantity column)
+	SplitRatio SplitRatio
+	// Account (or wallet) holding the lots the transaction applies to; transactions without an account belong to a single
+	// unnamed account. Transfers move lots from Account to ToAccount
+	Account   string
+	ToAccount string
+	// Line of the transaction log the transaction was read from, which errors processing it name (zero if it was not read
+	// from a log)
+	line int
+}
+
+// A LotDesignation names a lot to be consumed by a sale (specific identification)
+type LotDesignation struct {
+	LotID int
+	// Quantity of the lot to sell; zero designates whatever remains of the lot
+	Quantity Decimal
+}
+
+// Columns of a raw transaction, in order; columns from columnSymbol onwards are optional and may be omitted
+const (
+	columnDate = iota
+	columnType
+	columnPrice
+	columnQuantity
+	columnSymbol
+	columnFee
+	columnFeeCurrency
+	columnLots
+	columnAccount
+	columnToAccount
+	columnCount
+)
+
+// Number of columns every raw transaction must have
+const requiredColumnCount = columnSymbol
+
+// A ColumnError is a problem with a single column of a raw transaction
+type ColumnError struct {
+	// Column number, starting from 1; zero if the problem is with the transaction as a whole
+	Column int
+	Err    error
+}
+
+func (err *ColumnError) Error() string {
+	return err.Err.Error()
+}
+
+// Function to parse a raw transaction string (in CSV format) into a Transaction
+// Returns the first problem found, as a *ColumnError
+func ParseTransaction(rawTx string) (Transaction, error) {
+	tx, problems := parseTransactionColumns(rawTx)
+	if len(problems) != 0 {
+		return Transaction{}, problems[0]
+	}
+	return tx, nil
+}
+
+// Function to parse a raw transaction string (in CSV format), collecting the problems with every column
+// Columns that cannot be parsed are left at their zero value in the returned Transaction
+func parseTransactionColumns(rawTx string) (tx Transaction, problems []*ColumnError) {
+	problem := func(column int, format string, args ...interface{}) {
+		problems = append(problems, &ColumnError{Column: column + 1, Err: fmt.Errorf(format, args...)})
+	}
+	txArray := strings.Split(rawTx, ",")
+	if len(txArray) < requiredColumnCount || len(txArray) > columnCount {
+		problems = append(problems, &ColumnError{Err: fmt.Errorf("Invalid tx format; incorrect argument count (should be between %d and %d, got %d): %s", requiredColumnCount, columnCount, len(txArray), rawTx)})
+		return Transaction{}, problems
+	}
+
+	var err error
+	if tx.Date, err = ParseDate(txArray[columnDate]); err != nil {
+		problems = append(problems, &ColumnError{Column: columnDate + 1, Err: err})
+	}
+	tx.Type = TransactionType(strings.ToLower(txArray[columnType]))
+	if tx.Type != Buy && tx.Type != Sell && tx.Type != Split && tx.Type != Transfer {
+		problem(columnType, "Invalid order type (must be \"buy\", \"sell\", \"split\" or \"transfer\"): %s", tx.Type)
+	}
+	// Zero prices are only rejected when processing, since zero-price buys may be allowed (see Options.AllowZeroPriceBuys)
+	if tx.Price, err = ParseDecimal(txArray[columnPrice]); err != nil {
+		problem(columnPrice, "Invalid (non-float) price: %s", txArray[columnPrice])
+	} else if tx.Price.Sign() < 0 {
+		problem(columnPrice, "Invalid price (must not be negative): %s", txArray[columnPrice])
+	}
+	if tx.Type == Split {
+		if tx.SplitRatio, err = parseSplitRatio(txArray[columnQuantity]); err != nil {
+			problems = append(problems, &ColumnError{Column: columnQuantity + 1, Err: err})
+		}
+	} else if tx.Quantity, err = ParseDecimal(txArray[columnQuantity]); err != nil {
+		problem(columnQuantity, "Invalid (non-float) quantity: %s", txArray[columnQuantity])
+	} else if tx.Quantity.Sign() <= 0 {
+		problem(columnQuantity, "Invalid quantity (must be positive): %s", txArray[columnQuantity])
+	}
+	if len(txArray) > columnSymbol {
+		// Asset symbols are case-insensitive
+		tx.Symbol = strings.ToUpper(txArray[columnSymbol])
+	}
+	if len(txArray) > columnFee && txArray[columnFee] != "" {
+		if tx.Fee, err = ParseDecimal(txArray[columnFee]); err != nil {
+			problem(columnFee, "Invalid (non-float) fee: %s", txArray[columnFee])
+		} else if tx.Fee.Sign() < 0 {
+			problem(columnFee, "Invalid fee (must not be negative): %s", txArray[columnFee])
+		} else if tx.Type == Transfer && tx.Fee.Sign() != 0 {
+			problem(columnFee, "Invalid fee (transfers do not take fees; record a fee paid in units of the asset as a sale of them): %s", txArray[columnFee])
+		}
+	}
+	if len(txArray) > columnFeeCurrency {
+		tx.FeeCurrency = strings.ToUpper(txArray[columnFeeCurrency])
+		if tx.FeeCurrency != "" && tx.FeeCurrency == tx.Symbol {
+			// Fees paid in units of the asset itself are converted to the price currency at the transaction price
+			tx.Fee = tx.Fee.Mul(tx.Price)
+		}
+	}
+	if len(txArray) > columnLots && txArray[columnLots] != "" {
+		if tx.Type != Sell && tx.Type != Transfer {
+			problem(columnLots, "Invalid lot designation (only sell and transfer transactions may designate lots): %s", txArray[columnLots])
+		} else if tx.Designations, err = parseLotDesignations(txArray[columnLots]); err != nil {
+			problems = append(problems, &ColumnError{Column: columnLots + 1, Err: err})
+		}
+	}
+	if len(txArray) > columnAccount {
+		tx.Account = txArray[columnAccount]
+		if tx.Type == Split && tx.Account != "" {
+			problem(columnAccount, "Invalid account (splits apply to the asset in every account): %s", tx.Account)
+		}
+	}
+	if len(txArray) > columnToAccount {
+		tx.ToAccount = txArray[columnToAccount]
+	}
+	if tx.Type == Transfer && tx.ToAccount == tx.Account {
+		problem(columnToAccount, "Invalid transfer (must name a different account to transfer to): %s", tx.ToAccount)
+	} else if tx.Type != Transfer && tx.ToAccount != "" {
+		problem(columnToAccount, "Invalid account to transfer to (only transfer transactions move lots between accounts): %s", tx.ToAccount)
+	}
+	return tx, problems
+}
+
+// Function to parse the lots designated by a sale, as semicolon-separated lot ids each optionally followed by
+// a colon and the quantity to sell from that lot (e.g. "1:0.5;3"); a lot id without a quantity designates all of the lot
+func parseLotDesignations(rawDesignations string) ([]LotDesignation, error) {
+	var designations []LotDesignation
+	for _, rawDesignation := range strings.Split(rawDesignations, ";") {
+		rawID, rawQuantity := rawDesignation, ""
+		if colon := strings.IndexByte(rawDesignation, ':'); colon >= 0 {
+			rawID, rawQuantity = rawDesignation[:colon], rawDesignation[colon+1:]
+		}
+		lotID, err := strconv.Atoi(rawID)
+		if err != nil || lotID < 1 {
+			return nil, fmt.Errorf("Invalid lot designation (lot id must be a positive integer): %s", rawDesignation)
+		}
+		designation := LotDesignation{LotID: lotID}
+		if rawQuantity != "" {
+			designation.Quantity, err = ParseDecimal(rawQuantity)
+			if err != nil || designation.Quantity.Sign() <= 0 {
+				return nil, fmt.Errorf("Invalid lot designation (quantity must be a positive number): %s", rawDesignation)
+			}
+		}
+		designations = append(designations, designation)
+	}
+	return designations, nil
+}
+
+// Orderings of transactions that share the same date, applied by SortTransactions
+const (
+	SameDateBuysFirst  = SameDateOrder("buys-first")
+	SameDateSellsFirst = SameDateOrder("sells-first")
+	SameDateInputOrder = SameDateOrder("input")
+)
+
+// A SameDateOrder decides how SortTransactions orders transactions that share the same date
+type SameDateOrder string
+
+func (order SameDateOrder) String() string {
+	return string(order)
+}
+
+// Set implements flag.Value, accepting "buys-first", "sells-first" or "input"
+func (order *SameDateOrder) Set(s string) error {
+	switch candidate := SameDateOrder(strings.ToLower(s)); candidate {
+	case SameDateBuysFirst, SameDateSellsFirst, SameDateInputOrder:
+		*order = candidate
+		return nil
+	}
+	return fmt.Errorf("Invalid same-date order (must be one of \"buys-first\", \"sells-first\" or \"input\"): %s", s)
+}
+
+// Function to rank a transaction type among transactions sharing the same date (lower ranks come first)
+// Splits take effect at the start of their date, so they come before buys and sales (unless keeping the input order)
+func (order SameDateOrder) rank(txType TransactionType) int {
+	if order != SameDateInputOrder && txType == Split {
+		return -1
+	}
+	if (order == SameDateBuysFirst && txType == Buy) || (order == SameDateSellsFirst && txType == Sell) {
+		return 0
+	}
+	return 1
+}
+
+// Function to stably sort raw transactions by date, ordering transactions on the same date according to order
+// Transactions that compare equal keep their relative input order, and blank and comment lines are dropped
+// Returns an error naming the line number of the first transaction that cannot be parsed
+func SortTransactions(transactions []string, order SameDateOrder) ([]string, error) {
+	parsed, err := SortTransactionLog(transactions, order)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]string, len(parsed))
+	for idx, tx := range parsed {
+		sorted[idx] = transactions[tx.line-1]
+	}
+	return sorted, nil
+}
+
+// Function to parse every raw transaction in a transaction log (see ProcessTransactions) and stably sort them like
+// SortTransactions, for processing with Process; errors processing them still name their lines in the unsorted log
+// Returns an error naming the line number of the first transaction that cannot be parsed
+func SortTransactionLog(transactions []string, order SameDateOrder) ([]Transaction, error) {
+	parsed := make([]Transaction, 0, len(transactions))
+	for idx, rawTx := range transactions {
+		if isSkippedLine(rawTx) {
+			continue
+		}
+		tx, err := parseLogTransaction(rawTx, idx+1, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, tx)
+	}
+	sortTransactions(parsed, order)
+	return parsed, nil
+}
+
+// Function to stably sort transactions by date in place, ordering transactions on the same date according to order
+func sortTransactions(transactions []Transaction, order SameDateOrder) {
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if !transactions[i].Date.Equal(transactions[j].Date) {
+			return transactions[i].Date.Before(transactions[j].Date)
+		}
+		return order.rank(transactions[i].Type) < order.rank(transactions[j].Type)
+	})
+}
@@ -0,0 +1,65 @@
+package taxlot
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Function to withdraw the quantity of a transfer from the book, taking lots in the order decided by selector
+// Lots designated by the transfer (specific identification) are taken before any others
+// Returns the (possibly partial) lots taken, which keep the id, date, price and holding period of the lot they were taken
+// from, along with their share of its buy fees
+func (book *Book) Withdraw(transfer Transaction, selector LotSelector) ([]Lot, error) {
+	// The transfer works on a copy of the lots, so that the book is left unchanged if it fails
+	designated, lots, err := takeDesignated(append([]Lot(nil), book.lots...), transfer, selector)
+	if err != nil {
+		return nil, err
+	}
+	selector.Prioritize(lots)
+	lots = append(designated, lots...)
+	remaining := transfer.Quantity
+	var withdrawn []Lot
+	for remaining.Sign() > 0 && len(lots) > 0 {
+		portion := lots[0]
+		if lots[0].Quantity.Cmp(remaining) > 0 {
+			portion.Quantity = remaining
+			portion.Fee = lots[0].Fee.proRata(remaining, lots[0].Quantity)
+			lots[0].Quantity = lots[0].Quantity.Sub(remaining)
+			lots[0].Fee = lots[0].Fee.Sub(portion.Fee)
+		} else {
+			lots = lots[1:]
+		}
+		remaining = remaining.Sub(portion.Quantity)
+		withdrawn = append(withdrawn, portion)
+	}
+	if remaining.Sign() > 0 {
+		return nil, fmt.Errorf("Transfer quantity exceeded the quantity held in the account; please ensure that transaction log input is valid")
+	}
+	// After withdrawing, sort lots back to default chronological ordering
+	book.lots = lots
+	book.sortChronologically()
+	return withdrawn, nil
+}
+
+// Function to deposit lots withdrawn from another book, keeping their dates, prices and holding periods rather than
+// treating them as new purchases; they are numbered as new lots of this book, as ids are only unique within a book
+// With a pooling algorithm (average), lots are pooled together, and the pool keeps the date of its earliest lot
+func (book *Book) Deposit(lots []Lot, selector LotSelector) {
+	// Numbering the lots in chronological order, so that their new ids follow their dates
+	sorted := append([]Lot(nil), lots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	_, pooled := selector.(averageSelector)
+	for _, lot := range sorted {
+		if pooled && len(book.lots) != 0 {
+			if lot.Date.Before(book.lots[0].Date) {
+				book.lots[0].Date = lot.Date
+			}
+			book.lots[0].absorb(lot)
+			continue
+		}
+		book.add(lot)
+	}
+	book.sortChronologically()
+}
@@ -0,0 +1,159 @@
+package taxlot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransfers(t *testing.T) {
+	transactions := []string{
+		"2020-01-01,buy,100.00,3.00000000,BTC,3.00,,,exchange",
+		"2020-03-01,buy,200.00,2.00000000,BTC,,,,exchange",
+		"2020-04-01,buy,50.00,1.00000000,ETH",
+		"2020-05-01,transfer,0,4.00000000,BTC,,,,exchange,ledger",
+		"2020-06-01,sell,300.00,1.50000000,BTC,,,,ledger",
+	}
+	testCases := []struct {
+		algorithm         string
+		expectedLots      string
+		expectedDisposals string
+	}{
+		// Transferred lots keep their dates and prices (including buy fees), and are numbered as new lots of the account
+		{"fifo", "1,2020-04-01,50.00,1.00000000,ETH\n" +
+			"2,2020-03-01,200.00,1.00000000,BTC,exchange\n" +
+			"1,2020-01-01,101.00,1.50000000,BTC,ledger\n" +
+			"2,2020-03-01,200.00,1.00000000,BTC,ledger\n",
+			"1,2020-01-01,2020-06-01,1.50000000,151.50,450.00,298.50\n"},
+		// The lots transferred are selected by the algorithm
+		{"hifo", "1,2020-04-01,50.00,1.00000000,ETH\n" +
+			"1,2020-01-01,101.00,1.00000000,BTC,exchange\n" +
+			"1,2020-01-01,101.00,2.00000000,BTC,ledger\n" +
+			"2,2020-03-01,200.00,0.50000000,BTC,ledger\n",
+			"2,2020-03-01,2020-06-01,1.50000000,300.00,450.00,150.00\n"},
+		// Pools of each account keep the average cost of the pool they were transferred from
+		{"average", "1,2020-04-01,50.00,1.00000000,ETH\n" +
+			"1,2020-01-01,140.60,1.00000000,BTC,exchange\n" +
+			"1,2020-01-01,140.60,2.50000000,BTC,ledger\n",
+			"1,2020-01-01,2020-06-01,1.50000000,210.90,450.00,239.10\n"},
+	}
+	for _, testCase := range testCases {
+		lots, disposals, err := ProcessTransactions(transactions, testCase.algorithm)
+		if err != nil {
+			t.Fatalf("ProcessTransactions (%s): %s", testCase.algorithm, err.Error())
+		}
+		var lotsOutput, disposalsOutput strings.Builder
+		for _, lot := range lots {
+			lotsOutput.WriteString(lot.String() + "\n")
+		}
+		for _, disposal := range disposals {
+			disposalsOutput.WriteString(disposal.String() + "\n")
+			if disposal.Account != "ledger" {
+				t.Errorf("ProcessTransactions (%s): Expected disposals from the ledger account ... got %q instead", testCase.algorithm, disposal.Account)
+			}
+		}
+		if lotsOutput.String() != testCase.expectedLots {
+			t.Errorf("ProcessTransactions (%s): Expected lots:\n%s... got:\n%s instead", testCase.algorithm, testCase.expectedLots, lotsOutput.String())
+		}
+		if disposalsOutput.String() != testCase.expectedDisposals {
+			t.Errorf("ProcessTransactions (%s): Expected disposals:\n%s... got:\n%s instead", testCase.algorithm, testCase.expectedDisposals, disposalsOutput.String())
+		}
+	}
+}
+
+func TestTransferDesignatedLots(t *testing.T) {
+	lots, _, err := ProcessTransactions([]string{
+		"2020-01-01,buy,100.00,1.00000000",
+		"2020-02-01,buy,200.00,1.00000000",
+		"2020-03-01,transfer,0,1.50000000,,,,2;1:0.5,,cold",
+	}, "specid")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	expected := []string{"1,2020-01-01,100.00,0.50000000", "1,2020-01-01,100.00,0.50000000,,cold", "2,2020-02-01,200.00,1.00000000,,cold"}
+	if len(lots) != len(expected) {
+		t.Fatalf("ProcessTransactions: Expected %v ... got %v instead", expected, lots)
+	}
+	for idx := range expected {
+		if lots[idx].String() != expected[idx] {
+			t.Errorf("ProcessTransactions: Expected lot %s ... got %s instead", expected[idx], lots[idx])
+		}
+	}
+}
+
+func TestTransferProblems(t *testing.T) {
+	testCases := []struct {
+		algorithm            string
+		transactions         []string
+		expectedErrorSnippet string
+	}{
+		{"fifo", []string{"2020-01-01,buy,100.00,1.00000000,,,,,a", "2020-02-01,transfer,0,2.00000000,,,,,a,b"}, "Transfer quantity exceeded the quantity held in the account"},
+		{"fifo", []string{"2020-01-01,buy,100.00,1.00000000", "2020-02-01,transfer,0,1.00000000,,,,,,"}, "must name a different account to transfer to"},
+		{"fifo", []string{"2020-01-01,buy,100.00,1.00000000", "2020-02-01,sell,100.00,1.00000000,,,,,,b"}, "only transfer transactions move lots between accounts"},
+		{"fifo", []string{"2020-01-01,split,0,2:1,,,,,a"}, "splits apply to the asset in every account"},
+		{"uk", []string{"2020-01-01,buy,100.00,1.00000000,,,,,a"}, "Accounts and transfers are not supported by the uk algorithm"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactions(testCase.transactions, testCase.algorithm)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactions (%s): Expected an error containing %q ... got %v instead", testCase.algorithm, testCase.expectedErrorSnippet, err)
+		}
+	}
+
+	problems, err := Validate(strings.NewReader("2020-01-01,buy,100.00,3,,,,,a\n2020-02-01,transfer,0,2,,,,,a,b\n2020-03-01,sell,100.00,1.5,,,,,a\n2020-03-01,sell,100.00,2,,,,,b\n"), "fifo", Options{}, "")
+	if err != nil {
+		t.Fatalf("Validate: %s", err.Error())
+	}
+	if len(problems) != 1 || problems[0].String() != "line 3, column 4: Sale quantity of 1.50000000 exceeds the 1.00000000 held in account a" {
+		t.Errorf("Validate: Expected transfers to move the quantity held between accounts ... got %v instead", problems)
+	}
+}
+
+func TestFailedTransfersLeaveBookUnchanged(t *testing.T) {
+	book := Book{}
+	for _, rawTx := range []string{"2021-01-01,buy,10000.00,1.00000000", "2021-01-02,buy,20000.00,1.00000000"} {
+		tx, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		book.Buy(tx, hifoSelector{})
+	}
+	want := book.Lots()
+
+	for _, rawTx := range []string{"2021-02-01,transfer,0,3.00000000,,,,1,a,b", "2021-02-01,transfer,0,1.00000000,,,,1;7,a,b"} {
+		transfer, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		selector := LotSelector(hifoSelector{})
+		if len(transfer.Designations) != 0 {
+			selector = specIDSelector{fallback: hifoSelector{}}
+		}
+		if _, err := book.Withdraw(transfer, selector); err == nil {
+			t.Errorf("Withdraw (%s): Expected an error, but none resulted", rawTx)
+		}
+		if got := book.Lots(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Withdraw (%s): Expected the book to be left unchanged as %v ... got %v instead", rawTx, want, got)
+		}
+	}
+}
+
+func TestSplitsApplyInEveryAccount(t *testing.T) {
+	lots, disposals, err := ProcessTransactions([]string{
+		"2020-01-01,buy,100.00,3.00000000,AAPL,,,,a",
+		"2020-01-01,buy,100.00,1.00000000,AAPL,,,,b",
+		"2021-01-01,split,30.00,2:3,AAPL",
+	}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	expected := "[1,2020-01-01,150.00,2.00000000,AAPL,a]"
+	if formatted := fmt.Sprint(lots); formatted != expected {
+		t.Errorf("ProcessTransactions: Expected lots %s ... got %s instead", expected, formatted)
+	}
+	// Cash in lieu is paid out by each account separately, so all of b's 0.66666667 units are paid out
+	if len(disposals) != 1 || disposals[0].Account != "b" || disposals[0].String() != "1,2020-01-01,2021-01-01,0.66666667,100.00,20.00,-80.00" {
+		t.Errorf("ProcessTransactions: Expected cash in lieu of account b's fractional units ... got %v instead", disposals)
+	}
+}
@@ -0,0 +1,165 @@
+package taxlot
+
+import (
+	"fmt"
+)
+
+// Share matching rules of UK capital gains tax, in the order they are applied; every Disposal matched under the uk
+// algorithm records the rule that matched it
+const (
+	UKRuleSameDay         = "same-day"
+	UKRuleBedAndBreakfast = "bed-and-breakfast"
+	UKRuleSection104      = "section-104"
+)
+
+// Disposals are matched against acquisitions made within this many days after them (the "bed and breakfasting" rule)
+const ukBedAndBreakfastDays = 30
+
+// UK share identification: disposals are matched against acquisitions on the same day first, then against acquisitions
+// in the following 30 days, and finally against the Section 104 pool of all other holdings at their average cost
+// The first two rules look ahead in the transaction log, so sales are matched by matchUKDisposals rather than Book.Sell
+type ukSelector struct{}
+
+func (ukSelector) Prioritize(lots []Lot) {
+	// The Section 104 pool is a single (pooled) lot, so there is nothing to do
+}
+
+// Function to match every sale in transactions (which must be in chronological order) under the UK share matching rules:
+//  1. same-day: acquisitions of the asset on the same date as the sale
+//  2. bed-and-breakfast: acquisitions of the asset in the 30 days after the sale, earliest first
+//  3. section-104: the pool of all acquisitions not matched by the rules above, at its average cost
+//
+// Same-day matches are made for every sale before any bed-and-breakfast matches, so that a later sale's same-day
+// acquisitions are not taken by an earlier sale; sales on the same date are matched in log order
+// Opening lots start off the Section 104 pool of their asset
+// Returns the Section 104 pools remaining after processing (grouped by asset symbol, in alphabetical order, as accounts
+// are not supported and every pool belongs to the unnamed account), along with the disposals realized by every sale, in log order and then in rule order
+func matchUKDisposals(transactions []Transaction, openingLots []Lot) (lots []Lot, disposals []Disposal, err error) {
+	// Acquisitions of each asset, with buys on the same date aggregated into a single lot (numbered after any opening lots)
+	acquisitions := map[string]*Book{}
+	pools := map[string]*Book{}
+	for _, lot := range openingLots {
+		pool, ok := pools[lot.Symbol]
+		if !ok {
+			pool = &Book{}
+			pools[lot.Symbol] = pool
+			acquisitions[lot.Symbol] = &Book{}
+		}
+		if err := pool.Open(lot, averageSelector{}); err != nil {
+			return nil, nil, err
+		}
+		acquisitions[lot.Symbol].lotCount = pool.lotCount
+	}
+	var sales []Transaction
+	for _, tx := range transactions {
+		switch tx.Type {
+		case Buy:
+			book, ok := acquisitions[tx.Symbol]
+			if !ok {
+				book = &Book{}
+				acquisitions[tx.Symbol] = book
+			}
+			book.Buy(tx, fifoSelector{})
+		case Sell:
+			if len(tx.Designations) != 0 {
+				return nil, nil, lineError(tx, fmt.Errorf("Problem executing sale (uk): Sale designates lots, which requires the \"specid\" algorithm"))
+			}
+			sales = append(sales, tx)
+		default:
+			return nil, nil, lineError(tx, fmt.Errorf("Invalid order type (must be either \"buy\" or \"sell\"): %s", tx.Type))
+		}
+	}
+
+	// sales are counted down as they are matched, and acquisitions as they are consumed
+	saleDisposals := make([][]Disposal, len(sales))
+	for idx := range sales {
+		sale := &sales[idx]
+		if book, ok := acquisitions[sale.Symbol]; ok {
+			for lotIdx := range book.lots {
+				if book.lots[lotIdx].Date.Equal(sale.Date) {
+					saleDisposals[idx] = append(saleDisposals[idx], matchUKAcquisition(&book.lots[lotIdx], sale, UKRuleSameDay)...)
+				}
+			}
+		}
+	}
+	for idx := range sales {
+		sale := &sales[idx]
+		if book, ok := acquisitions[sale.Symbol]; ok {
+			windowEnd := sale.Date.AddDate(0, 0, ukBedAndBreakfastDays)
+			for lotIdx := range book.lots {
+				lot := &book.lots[lotIdx]
+				if lot.Date.After(sale.Date) && !lot.Date.After(windowEnd) {
+					saleDisposals[idx] = append(saleDisposals[idx], matchUKAcquisition(lot, sale, UKRuleBedAndBreakfast)...)
+				}
+			}
+		}
+	}
+
+	// Unmatched acquisitions join the Section 104 pool of their asset on their date, and the unmatched remainder of each
+	// sale is taken from the pool as it stands on the date of the sale
+	pooledCount := map[string]int{}
+	joinPool := func(symbol string, until func(Lot) bool) {
+		pool, ok := pools[symbol]
+		if !ok {
+			pool = &Book{}
+			pools[symbol] = pool
+		}
+		book := acquisitions[symbol]
+		for book != nil && pooledCount[symbol] < len(book.lots) && until(book.lots[pooledCount[symbol]]) {
+			lot := book.lots[pooledCount[symbol]]
+			pooledCount[symbol]++
+			if lot.Quantity.Sign() == 0 {
+				continue
+			}
+			if len(pool.lots) == 0 {
+				// The pool keeps the id and date of the first acquisition to join it
+				pool.lots = append(pool.lots, lot)
+			} else {
+				pool.lots[0].absorb(lot)
+			}
+		}
+	}
+	for idx, sale := range sales {
+		joinPool(sale.Symbol, func(lot Lot) bool { return !lot.Date.After(sale.Date) })
+		if sale.Quantity.Sign() == 0 {
+			continue
+		}
+		poolDisposals, err := pools[sale.Symbol].Sell(sale, averageSelector{})
+		if err != nil {
+			return nil, nil, lineError(sale, fmt.Errorf("Problem executing sale (uk): %s", err.Error()))
+		}
+		for _, disposal := range poolDisposals {
+			disposal.Rule = UKRuleSection104
+			saleDisposals[idx] = append(saleDisposals[idx], disposal)
+		}
+	}
+	for symbol, book := range acquisitions {
+		joinPool(symbol, func(Lot) bool { return true })
+		// Acquisitions were numbered after the opening lots, so the pool's next lot id is that of the acquisitions
+		pools[symbol].lotCount = book.lotCount
+	}
+
+	for _, matched := range saleDisposals {
+		disposals = append(disposals, matched...)
+	}
+	return collectLots(pools), disposals, nil
+}
+
+// Function to match as much of sale as possible against lot under the given rule, counting both down by the quantity matched
+// Returns the resulting Disposal record, or nothing if either the lot or the sale has no quantity left
+func matchUKAcquisition(lot *Lot, sale *Transaction, rule string) []Disposal {
+	quantity := lot.Quantity
+	if sale.Quantity.Cmp(quantity) < 0 {
+		quantity = sale.Quantity
+	}
+	if quantity.Sign() == 0 {
+		return nil
+	}
+	disposal := newDisposal(*lot, *sale, quantity)
+	disposal.Rule = rule
+	lot.Quantity = lot.Quantity.Sub(quantity)
+	lot.Fee = lot.Fee.Sub(disposal.AcquisitionFee)
+	sale.Quantity = sale.Quantity.Sub(quantity)
+	sale.Fee = sale.Fee.Sub(disposal.SaleFee)
+	return []Disposal{disposal}
+}
@@ -0,0 +1,92 @@
+package taxlot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUKShareMatching(t *testing.T) {
+	resultingLots, disposals, err := ProcessTransactions([]string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-02-01,buy,200.00,10.00000000",
+		"2021-03-01,sell,300.00,5.00000000",
+		"2021-03-01,buy,250.00,2.00000000",
+		"2021-03-15,buy,280.00,1.00000000",
+		"2021-05-01,buy,260.00,4.00000000",
+		"2021-06-01,sell,400.00,10.00000000",
+	}, "uk")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+
+	// Everything not matched by the same-day or bed-and-breakfast rules ends up in the Section 104 pool (lot 1)
+	want := "1,2021-01-01,170.00,12.00000000"
+	if len(resultingLots) != 1 || resultingLots[0].String() != want {
+		t.Errorf("ProcessTransactions: Expected a single Section 104 pool %s ... got %v instead", want, resultingLots)
+	}
+
+	expectedDisposals := []struct {
+		formatted string
+		Rule      string
+	}{
+		{"3,2021-03-01,2021-03-01,2.00000000,500.00,600.00,100.00", UKRuleSameDay},
+		{"4,2021-03-15,2021-03-01,1.00000000,280.00,300.00,20.00", UKRuleBedAndBreakfast},
+		{"1,2021-01-01,2021-03-01,2.00000000,300.00,600.00,300.00", UKRuleSection104},
+		{"1,2021-01-01,2021-06-01,10.00000000,1700.00,4000.00,2300.00", UKRuleSection104},
+	}
+	if len(disposals) != len(expectedDisposals) {
+		t.Fatalf("ProcessTransactions: Expected %d disposals back, got %v instead", len(expectedDisposals), disposals)
+	}
+	for idx, want := range expectedDisposals {
+		if got := disposals[idx].String(); got != want.formatted {
+			t.Errorf("ProcessTransactions: Expected disposals[%d].String() to be %s ... got %s instead", idx, want.formatted, got)
+		}
+		if disposals[idx].Rule != want.Rule {
+			t.Errorf("ProcessTransactions: Expected disposals[%d] rule to be %s ... got %s instead", idx, want.Rule, disposals[idx].Rule)
+		}
+	}
+}
+
+func TestUKSameDayBeforeBedAndBreakfast(t *testing.T) {
+	// The 2021-01-20 buy is matched to the sale on its own day, not to the earlier sale whose 30-day window it falls in
+	_, disposals, err := ProcessTransactions([]string{
+		"2021-01-01,buy,100.00,5.00000000",
+		"2021-01-10,sell,150.00,1.00000000",
+		"2021-01-20,buy,120.00,1.00000000",
+		"2021-01-20,sell,130.00,1.00000000",
+	}, "uk")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	expectedRules := []string{UKRuleSection104, UKRuleSameDay}
+	if len(disposals) != len(expectedRules) {
+		t.Fatalf("ProcessTransactions: Expected %d disposals back, got %v instead", len(expectedRules), disposals)
+	}
+	for idx, want := range expectedRules {
+		if disposals[idx].Rule != want {
+			t.Errorf("ProcessTransactions: Expected disposals[%d] rule to be %s ... got %s instead", idx, want, disposals[idx].Rule)
+		}
+	}
+}
+
+func TestUKShareMatchingErrors(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		transactions         []string
+		expectedErrorSnippet string
+	}{
+		{"oversell", []string{"2021-01-01,buy,100.00,1.00000000", "2021-01-02,sell,100.00,2.00000000"}, "Sale quantity exceeded total buy quantity"},
+		{"sale before any buy", []string{"2021-01-01,sell,100.00,1.00000000", "2021-03-01,buy,100.00,1.00000000"}, "Sale quantity exceeded total buy quantity"},
+		{"designations", []string{"2021-01-01,buy,100.00,1.00000000", "2021-01-02,sell,100.00,1.00000000,,,,1"}, "Sale designates lots, which requires the \"specid\" algorithm"},
+	}
+	for _, testCase := range testCases {
+		_, _, err := ProcessTransactions(testCase.transactions, "uk")
+		if err == nil {
+			t.Errorf("ProcessTransactions (%s): Expected an error, but none resulted", testCase.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ProcessTransactions (%s): Expected error containing \"%s\" ... got \"%s\" instead", testCase.name, testCase.expectedErrorSnippet, err.Error())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package taxlot
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A Quote is the market price of an asset on a date
+// Quotes without a symbol price every asset that has no quotes of its own
+type Quote struct {
+	Date   time.Time
+	Price  Decimal
+	Symbol string
+}
+
+// Function to parse a quote file read from in, holding one quote per line in the format of date,price[,symbol]
+// Blank and comment lines are skipped (see TransactionScanner); quotes may be in any order
+func ParseQuotes(in io.Reader) ([]Quote, error) {
+	var quotes []Quote
+	scanner := NewTransactionScanner(in)
+	for scanner.Scan() {
+		quote, err := parseQuote(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("Problem parsing quote on line %d (%s): %s", scanner.Line(), scanner.Text(), err.Error())
+		}
+		quotes = append(quotes, quote)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// Function to parse a single raw quote in the format of date,price[,symbol]
+func parseQuote(rawQuote string) (Quote, error) {
+	quoteArray := strings.Split(rawQuote, ",")
+	if len(quoteArray) < 2 || len(quoteArray) > 3 {
+		return Quote{}, fmt.Errorf("Invalid quote format; incorrect argument count (should be between 2 and 3, got %d)", len(quoteArray))
+	}
+	date, err := ParseDate(quoteArray[0])
+	if err != nil {
+		return Quote{}, err
+	}
+	price, err := ParseDecimal(quoteArray[1])
+	if err != nil {
+		return Quote{}, fmt.Errorf("Invalid (non-float) price: %s", quoteArray[1])
+	}
+	if price.Sign() < 0 {
+		return Quote{}, fmt.Errorf("Invalid price (must not be negative): %s", quoteArray[1])
+	}
+	quote := Quote{Date: date, Price: price}
+	if len(quoteArray) > 2 {
+		quote.Symbol = strings.ToUpper(quoteArray[2])
+	}
+	return quote, nil
+}
+
+// Function to find the market price of the asset with the given symbol as of date: the price of its latest quote dated
+// on or before date (falling back to quotes without a symbol if the asset has no quotes of its own)
+// Returns false if there is no such quote
+func MarketPrice(quotes []Quote, symbol string, date time.Time) (Decimal, bool) {
+	for _, candidateSymbol := range []string{symbol, ""} {
+		var latest *Quote
+		hasQuotes := false
+		for idx := range quotes {
+			quote := &quotes[idx]
+			if quote.Symbol != candidateSymbol {
+				continue
+			}
+			hasQuotes = true
+			if !quote.Date.After(date) && (latest == nil || !quote.Date.Before(latest.Date)) {
+				latest = quote
+			}
+		}
+		if latest != nil {
+			return latest.Price, true
+		}
+		if hasQuotes {
+			break
+		}
+	}
+	return Decimal{}, false
+}
+
+// An UnrealizedGain is the value of a remaining lot at its market price, as of a valuation date
+type UnrealizedGain struct {
+	Lot           Lot
+	ValuationDate time.Time
+	MarketPrice   Decimal
+	// Cost basis of the lot (including buy fees) and its market value, at the market price
+	CostBasis   Decimal
+	MarketValue Decimal
+	// Holding period classification (ShortTerm or LongTerm) as of the valuation date, and the number of days held
+	Term        string
+	HoldingDays int
+}
+
+// Unrealized gain of the lot; negative values are losses
+func (gain UnrealizedGain) Gain() Decimal {
+	return gain.MarketValue.Sub(gain.CostBasis)
+}
+
+func (gain UnrealizedGain) String() string {
+	return fmt.Sprintf("%d,%s,%s,%s,%s,%s,%s", gain.Lot.ID, gain.Lot.Date.Format(DateLayout), gain.ValuationDate.Format(DateLayout), gain.Lot.Quantity.StringFixed(8), gain.CostBasis.StringFixed(2), gain.MarketValue.StringFixed(2), gain.Gain().StringFixed(2))
+}
+
+// Function to value the remaining lots at their market price as of valuationDate (see MarketPrice), classifying their
+// holding period with the given long-term threshold
+// Returns an error if a lot has no market price, or was acquired after valuationDate
+func ValueLots(lots []Lot, quotes []Quote, valuationDate time.Time, longTermThreshold HoldingPeriod) ([]UnrealizedGain, error) {
+	gains := make([]UnrealizedGain, 0, len(lots))
+	for _, lot := range lots {
+		if lot.Date.After(valuationDate) {
+			return nil, fmt.Errorf("Lot %s was acquired after the valuation date (%s)", lot, valuationDate.Format(DateLayout))
+		}
+		price, ok := MarketPrice(quotes, lot.Symbol, valuationDate)
+		if !ok {
+			return nil, fmt.Errorf("No market price on or before the valuation date (%s) for lot %s", valuationDate.Format(DateLayout), lot)
+		}
+		holdingStart := lot.HoldingPeriodStart()
+		gains = append(gains, UnrealizedGain{
+			Lot:           lot,
+			ValuationDate: valuationDate,
+			MarketPrice:   price,
+			CostBasis:     lot.Price.Mul(lot.Quantity),
+			MarketValue:   price.Mul(lot.Quantity),
+			Term:          longTermThreshold.Classify(holdingStart, valuationDate),
+			HoldingDays:   int(valuationDate.Sub(holdingStart).Hours() / 24),
+		})
+	}
+	return gains, nil
+}
+
+// Unrealized gains totals of every lot with the same holding period classification
+type UnrealizedSummary struct {
+	Term        string
+	CostBasis   Decimal
+	MarketValue Decimal
+}
+
+// Unrealized gain of all lots in the summary; negative values are losses
+func (summary UnrealizedSummary) Gain() Decimal {
+	return summary.MarketValue.Sub(summary.CostBasis)
+}
+
+// Function to total unrealized gains per holding period classification
+// Returns the short-term totals before the long-term totals, omitting either if there are no such lots
+func SummarizeUnrealized(gains []UnrealizedGain) []UnrealizedSummary {
+	var summaries []UnrealizedSummary
+	for _, term := range []string{ShortTerm, LongTerm} {
+		summary := UnrealizedSummary{Term: term}
+		found := false
+		for _, gain := range gains {
+			if gain.Term == term {
+				summary.CostBasis = summary.CostBasis.Add(gain.CostBasis)
+				summary.MarketValue = summary.MarketValue.Add(gain.MarketValue)
+				found = true
+			}
+		}
+		if found {
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries
+}
+
+// Function to write unrealized gains to out, one lot per line in the format of
+// lotId,acquiredDate,valuationDate,quantity,costBasis,marketValue,unrealizedGain,term,symbol,marketPrice,holdingDays,account
+// followed by a totals line per holding period in the format of total,term,costBasis,marketValue,unrealizedGain
+func WriteUnrealizedGains(out io.Writer, gains []UnrealizedGain) error {
+	for _, gain := range gains {
+		if _, err := fmt.Fprintf(out, "%s,%s,%s,%s,%d,%s\n", gain.String(), gain.Term, gain.Lot.Symbol, gain.MarketPrice.StringFixed(2), gain.HoldingDays, gain.Lot.Account); err != nil {
+			return err
+		}
+	}
+	for _, summary := range SummarizeUnrealized(gains) {
+		if _, err := fmt.Fprintf(out, "total,%s,%s,%s,%s\n", summary.Term, summary.CostBasis.StringFixed(2), summary.MarketValue.StringFixed(2), summary.Gain().StringFixed(2)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
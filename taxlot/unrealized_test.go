@@ -0,0 +1,106 @@
+package taxlot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseQuotes(t *testing.T) {
+	quotes, err := ParseQuotes(strings.NewReader("# date,price,symbol\n2021-06-01,120.00\n2021-06-01,50.00,eth\n"))
+	if err != nil {
+		t.Fatalf("ParseQuotes: %s", err.Error())
+	}
+	if len(quotes) != 2 || quotes[1].Symbol != "ETH" || quotes[1].Price.Cmp(DecimalFromInt(50)) != 0 {
+		t.Errorf("ParseQuotes: Expected 2 quotes, the second for 50.00 ETH ... got %v instead", quotes)
+	}
+
+	testCases := []struct {
+		input                string
+		expectedErrorSnippet string
+	}{
+		{"2021-06-01,120.00\n2021-06-31,120.00", "Problem parsing quote on line 2 (2021-06-31,120.00): Invalid date"},
+		{"2021-06-01", "Invalid quote format; incorrect argument count (should be between 2 and 3, got 1)"},
+		{"2021-06-01,NaN", "Invalid (non-float) price: NaN"},
+		{"2021-06-01,-1", "Invalid price (must not be negative): -1"},
+	}
+	for _, testCase := range testCases {
+		_, err := ParseQuotes(strings.NewReader(testCase.input))
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ParseQuotes: Expected error containing \"%s\" ... got \"%v\" instead", testCase.expectedErrorSnippet, err)
+		}
+	}
+}
+
+func TestMarketPrice(t *testing.T) {
+	quotes := []Quote{
+		{Date: mustParseDate("2021-06-01"), Price: DecimalFromInt(120)},
+		{Date: mustParseDate("2021-07-01"), Price: DecimalFromInt(130)},
+		{Date: mustParseDate("2021-06-15"), Price: DecimalFromInt(50), Symbol: "ETH"},
+	}
+	testCases := []struct {
+		symbol   string
+		date     string
+		expected Decimal
+		ok       bool
+	}{
+		{"", "2021-06-30", DecimalFromInt(120), true},
+		{"", "2021-07-01", DecimalFromInt(130), true},
+		{"", "2021-05-31", Decimal{}, false},
+		// Assets without quotes of their own fall back to quotes without a symbol
+		{"BTC", "2021-07-01", DecimalFromInt(130), true},
+		{"ETH", "2021-07-01", DecimalFromInt(50), true},
+		{"ETH", "2021-06-14", Decimal{}, false},
+	}
+	for _, testCase := range testCases {
+		price, ok := MarketPrice(quotes, testCase.symbol, mustParseDate(testCase.date))
+		if price.Cmp(testCase.expected) != 0 || ok != testCase.ok {
+			t.Errorf("MarketPrice(%s, %s): Expected %s (%t) ... got %s (%t) instead", testCase.symbol, testCase.date, testCase.expected, testCase.ok, price, ok)
+		}
+	}
+}
+
+func TestValueLots(t *testing.T) {
+	lots, _, err := ProcessTransactionsWithOptions([]string{
+		"2020-01-01,buy,100.00,10.00000000",
+		"2021-03-10,sell,80.00,5.00000000",
+		"2021-03-20,buy,70.00,2.00000000,ETH,,,,exchange",
+	}, "fifo", Options{})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+	quotes := []Quote{
+		{Date: mustParseDate("2021-01-01"), Price: DecimalFromInt(90)},
+		{Date: mustParseDate("2021-07-01"), Price: DecimalFromInt(130)},
+		{Date: mustParseDate("2021-06-01"), Price: DecimalFromInt(50), Symbol: "ETH"},
+	}
+	gains, err := ValueLots(lots, quotes, mustParseDate("2021-07-01"), DefaultLongTermThreshold)
+	if err != nil {
+		t.Fatalf("ValueLots: %s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := WriteUnrealizedGains(&out, gains); err != nil {
+		t.Fatalf("WriteUnrealizedGains: %s", err.Error())
+	}
+	want := "1,2020-01-01,2021-07-01,5.00000000,500.00,650.00,150.00,long,,130.00,547,\n" +
+		"1,2021-03-20,2021-07-01,2.00000000,140.00,100.00,-40.00,short,ETH,50.00,103,exchange\n" +
+		"total,short,140.00,100.00,-40.00\n" +
+		"total,long,500.00,650.00,150.00\n"
+	if out.String() != want {
+		t.Errorf("WriteUnrealizedGains: Expected:\n%s... got:\n%s instead", want, out.String())
+	}
+
+	testCases := []struct {
+		date                 string
+		expectedErrorSnippet string
+	}{
+		{"2021-03-19", "Lot 1,2021-03-20,70.00,2.00000000,ETH,exchange was acquired after the valuation date (2021-03-19)"},
+		{"2021-05-31", "No market price on or before the valuation date (2021-05-31) for lot 1,2021-03-20,70.00,2.00000000,ETH,exchange"},
+	}
+	for _, testCase := range testCases {
+		_, err := ValueLots(lots, quotes, mustParseDate(testCase.date), DefaultLongTermThreshold)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectedErrorSnippet) {
+			t.Errorf("ValueLots: Expected error containing \"%s\" ... got \"%v\" instead", testCase.expectedErrorSnippet, err)
+		}
+	}
+}
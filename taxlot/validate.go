@@ -0,0 +1,177 @@
+package taxlot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// A ValidationProblem is a problem found in a transaction log by Validate
+type ValidationProblem struct {
+	Line int
+	// Column number, starting from 1; zero if the problem is with the transaction as a whole
+	Column int
+	Reason string
+}
+
+// Problems are formatted as line N, column N: reason (leaving out the column if the problem is with the whole transaction)
+func (problem ValidationProblem) String() string {
+	if problem.Column == 0 {
+		return fmt.Sprintf("line %d: %s", problem.Line, problem.Reason)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", problem.Line, problem.Column, problem.Reason)
+}
+
+// Function to check a whole transaction log read from in (see TransactionScanner) without processing it, collecting every
+// problem found rather than stopping at the first one
+// Besides transactions that cannot be parsed, the problems found are zero prices (unless allowed by options for buys),
+// transactions dated before the preceding transaction
+// and sales of more than the quantity of the asset held at the time, including opening lots (for the uk algorithm, acquisitions within 30 days
+// after a sale count as held, since the sale may be matched with them), transfers of more than the quantity held in the
+// account they are from, along with splits, accounts and transfers under the uk algorithm, and lot designations that are
+// not supported by the algorithm or name lots not held at the time
+// If sortOrder is not empty, the log is checked as if sorted by SortTransactions with sortOrder, so its order is not checked
+// Returns the problems in order of line number, or an error if the log cannot be read, or algorithm or options are invalid
+func Validate(in io.Reader, algorithm string, options Options, sortOrder SameDateOrder) ([]ValidationProblem, error) {
+	selector, err := LookupLotSelector(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOptions(algorithm, selector, options); err != nil {
+		return nil, err
+	}
+	_, designatable := selector.(specIDSelector)
+
+	var problems []ValidationProblem
+	var transactions []Transaction
+	var previousDate time.Time
+	scanner := NewTransactionScanner(in)
+	for scanner.Scan() {
+		tx, columnErrors := parseTransactionColumns(scanner.Text())
+		for _, columnError := range columnErrors {
+			problems = append(problems, ValidationProblem{Line: scanner.Line(), Column: columnError.Column, Reason: columnError.Error()})
+		}
+		if len(columnErrors) != 0 {
+			continue
+		}
+		if err := checkTransaction(tx, options); err != nil {
+			problems = append(problems, ValidationProblem{Line: scanner.Line(), Column: columnPrice + 1, Reason: err.Error()})
+			continue
+		}
+		if len(tx.Designations) != 0 && !designatable {
+			problems = append(problems, ValidationProblem{Line: scanner.Line(), Column: columnLots + 1, Reason: "Sale designates lots, which requires the \"specid\" algorithm"})
+		}
+		if sortOrder == "" && tx.Date.Before(previousDate) {
+			problems = append(problems, ValidationProblem{Line: scanner.Line(), Column: columnDate + 1, Reason: fmt.Sprintf("Transaction is dated %s, before the preceding transaction (%s)", tx.Date.Format(DateLayout), previousDate.Format(DateLayout))})
+			continue
+		}
+		previousDate = tx.Date
+		tx.line = scanner.Line()
+		transactions = append(transactions, tx)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if sortOrder != "" {
+		sortTransactions(transactions, sortOrder)
+	}
+
+	// Quantity of every asset held in every account, counting the buys before each sale (for uk, along with those in the
+	// following 30 days)
+	type holding struct {
+		account string
+		symbol  string
+	}
+	_, lookAhead := selector.(ukSelector)
+	held := map[holding]Decimal{}
+	for _, lot := range options.OpeningLots {
+		held[holding{lot.Account, lot.Symbol}] = held[holding{lot.Account, lot.Symbol}].Add(lot.Quantity)
+	}
+	// With specific identification, transactions are also applied to a ledger, to check the lots designated against the
+	// lots held at the time
+	var ledger *Ledger
+	if designatable {
+		if ledger, err = NewLedger(algorithm, options); err != nil {
+			return nil, err
+		}
+	}
+	apply := func(tx Transaction) {
+		if ledger == nil {
+			return
+		}
+		if _, err := ledger.Apply(tx); err != nil {
+			problems = append(problems, ValidationProblem{Line: tx.line, Reason: err.Error()})
+		}
+	}
+	bought := 0
+	for idx, tx := range transactions {
+		if lookAhead && tx.Type == Split {
+			problems = append(problems, ValidationProblem{Line: tx.line, Column: columnType + 1, Reason: fmt.Sprintf("Splits are not supported by the %s algorithm", algorithm)})
+			continue
+		}
+		if lookAhead && (tx.Type == Transfer || tx.Account != "") {
+			column := columnAccount
+			if tx.Type == Transfer {
+				column = columnType
+			}
+			problems = append(problems, ValidationProblem{Line: tx.line, Column: column + 1, Reason: fmt.Sprintf("Accounts and transfers are not supported by the %s algorithm", algorithm)})
+			continue
+		}
+		if tx.Type == Buy {
+			apply(tx)
+			continue
+		}
+		windowEnd := tx.Date.AddDate(0, 0, ukBedAndBreakfastDays)
+		for ; bought < len(transactions) && (bought < idx || (lookAhead && !transactions[bought].Date.After(windowEnd))); bought++ {
+			if transactions[bought].Type == Buy {
+				held[holding{transactions[bought].Account, transactions[bought].Symbol}] = held[holding{transactions[bought].Account, transactions[bought].Symbol}].Add(transactions[bought].Quantity)
+			}
+		}
+		if tx.Type == Split {
+			// Splits apply in every account, and fractional units paid out as cash in lieu are no longer held
+			for key, quantity := range held {
+				if key.symbol != tx.Symbol {
+					continue
+				}
+				quantity = quantity.proRata(tx.SplitRatio.After, tx.SplitRatio.Before)
+				if tx.Price.Sign() != 0 {
+					quantity = quantity.Sub(quantity.fraction())
+				}
+				held[key] = quantity
+			}
+			apply(tx)
+			continue
+		}
+		from := holding{tx.Account, tx.Symbol}
+		if ledger != nil && len(tx.Designations) != 0 {
+			if _, _, err := takeDesignated(ledger.book(tx.Account, tx.Symbol).Lots(), tx, selector); err != nil {
+				problems = append(problems, ValidationProblem{Line: tx.line, Column: columnLots + 1, Reason: err.Error()})
+				continue
+			}
+		}
+		if tx.Quantity.Cmp(held[from]) > 0 {
+			description := "Sale"
+			if tx.Type == Transfer {
+				description = "Transfer"
+			}
+			inAccount := ""
+			if tx.Account != "" {
+				inAccount = " in account " + tx.Account
+			}
+			problems = append(problems, ValidationProblem{Line: tx.line, Column: columnQuantity + 1, Reason: fmt.Sprintf("%s quantity of %s exceeds the %s held%s", description, tx.Quantity, held[from], inAccount)})
+			held[from] = Decimal{}
+			continue
+		}
+		held[from] = held[from].Sub(tx.Quantity)
+		if tx.Type == Transfer {
+			held[holding{tx.ToAccount, tx.Symbol}] = held[holding{tx.ToAccount, tx.Symbol}].Add(tx.Quantity)
+		}
+		apply(tx)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems, nil
+}
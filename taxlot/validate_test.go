@@ -0,0 +1,109 @@
+package taxlot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	transactionLog := strings.Join([]string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-13-01,buy,100.00,1.00000000",
+		"2021-01-02,sel,x,-1",
+		"",
+		"2021-01-03,sell,100.00,20.00000000",
+		"2020-01-01,buy,100.00,1.00000000",
+		"2021-01-04,buy,100.00",
+		"2021-01-05,sell,100.00,1.00000000,ETH",
+		"2021-01-06,buy,0,1.00000000",
+	}, "\n")
+	problems, err := Validate(strings.NewReader(transactionLog), "fifo", Options{}, "")
+	if err != nil {
+		t.Fatalf("Validate: %s", err.Error())
+	}
+	expectedProblems := []string{
+		"line 2, column 1: Invalid date (must be in YYYY-MM-DD format): 2021-13-01",
+		"line 3, column 2: Invalid order type (must be \"buy\", \"sell\", \"split\" or \"transfer\"): sel",
+		"line 3, column 3: Invalid (non-float) price: x",
+		"line 3, column 4: Invalid quantity (must be positive): -1",
+		"line 5, column 4: Sale quantity of 20.00000000 exceeds the 10.00000000 held",
+		"line 6, column 1: Transaction is dated 2020-01-01, before the preceding transaction (2021-01-03)",
+		"line 7: Invalid tx format; incorrect argument count (should be between 4 and 10, got 3): 2021-01-04,buy,100.00",
+		"line 8, column 4: Sale quantity of 1.00000000 exceeds the 0.00000000 held",
+		"line 9, column 3: Invalid price (must be positive, unless zero-price buys such as gifts are allowed with -allow-zero-price): 0.00000000",
+	}
+	formatted := make([]string, len(problems))
+	for idx, problem := range problems {
+		formatted[idx] = problem.String()
+	}
+	if !reflect.DeepEqual(formatted, expectedProblems) {
+		t.Errorf("Validate: Expected problems %q ... got %q instead", expectedProblems, formatted)
+	}
+}
+
+func TestValidateOrderAndAlgorithm(t *testing.T) {
+	testCases := []struct {
+		transactionLog   string
+		algorithm        string
+		sortOrder        SameDateOrder
+		expectedProblems int
+	}{
+		{"2021-01-01,buy,100.00,1.00000000\n2021-01-02,sell,100.00,1.00000000", "fifo", "", 0},
+		// A sale before a buy on the same date oversells, unless buys are sorted first
+		{"2021-01-01,sell,100.00,1.00000000\n2021-01-01,buy,100.00,1.00000000", "fifo", "", 1},
+		{"2021-01-01,sell,100.00,1.00000000\n2021-01-01,buy,100.00,1.00000000", "fifo", SameDateBuysFirst, 0},
+		// Out-of-order transactions are only a problem when the log is not sorted (and the out-of-order buy does not cover the sale)
+		{"2021-01-02,sell,100.00,1.00000000\n2021-01-01,buy,100.00,1.00000000", "fifo", "", 2},
+		{"2021-01-02,sell,100.00,1.00000000\n2021-01-01,buy,100.00,1.00000000", "fifo", SameDateInputOrder, 0},
+		// The uk algorithm may match a sale with acquisitions in the following 30 days
+		{"2021-01-01,sell,100.00,1.00000000\n2021-01-31,buy,100.00,1.00000000", "uk", "", 0},
+		{"2021-01-01,sell,100.00,1.00000000\n2021-02-01,buy,100.00,1.00000000", "uk", "", 1},
+	}
+	for idx, testCase := range testCases {
+		problems, err := Validate(strings.NewReader(testCase.transactionLog), testCase.algorithm, Options{}, testCase.sortOrder)
+		if err != nil {
+			t.Fatalf("Validate #%d: %s", idx, err.Error())
+		}
+		if len(problems) != testCase.expectedProblems {
+			t.Errorf("Validate #%d: Expected %d problem(s) ... got %v instead", idx, testCase.expectedProblems, problems)
+		}
+	}
+
+	if _, err := Validate(strings.NewReader(""), "lofi", Options{}, ""); err == nil || !strings.Contains(err.Error(), "Invalid algorithm") {
+		t.Errorf("Validate: Expected an invalid algorithm error ... got %v instead", err)
+	}
+}
+
+func TestValidateDesignationsAndOptions(t *testing.T) {
+	testCases := []struct {
+		transactionLog   string
+		algorithm        string
+		expectedProblems []string
+	}{
+		{"2021-01-01,buy,100.00,1.00000000\n2021-01-02,sell,100.00,1.00000000,,,,1", "fifo", []string{"line 2, column 8: Sale designates lots, which requires the \"specid\" algorithm"}},
+		{"2021-01-01,buy,100.00,1.00000000\n2021-01-02,sell,100.00,1.00000000,,,,7", "specid", []string{"line 2, column 8: Designated lot 7 does not exist (or has already been sold)"}},
+		{"2021-01-01,buy,100.00,1.00000000\n2021-01-02,buy,100.00,1.00000000\n2021-01-03,sell,100.00,1.00000000,,,,1\n2021-01-04,sell,100.00,1.00000000,,,,1", "specid", []string{"line 4, column 8: Designated lot 1 does not exist (or has already been sold)"}},
+		{"2021-01-01,buy,100.00,1.00000000\n2021-01-02,buy,100.00,1.00000000\n2021-01-03,sell,100.00,0.50000000,,,,1:0.75", "specid", []string{"line 3, column 8: Designated lot quantities exceed the sale quantity of 0.50000000"}},
+		// Lots are numbered per account, and transfers number the lots they move as new lots of the account they move to
+		{"2021-01-01,buy,100.00,1.00000000,,,,,a\n2021-01-02,buy,100.00,1.00000000,,,,,b\n2021-01-03,transfer,0,1.00000000,,,,,a,b\n2021-01-04,sell,100.00,1.00000000,,,,2,b", "specid", nil},
+		{"2021-01-01,buy,100.00,1.00000000,,,,,a\n2021-01-02,sell,100.00,1.00000000,,,,1,b", "specid", []string{"line 2, column 8: Designated lot 1 does not exist (or has already been sold)"}},
+	}
+	for idx, testCase := range testCases {
+		problems, err := Validate(strings.NewReader(testCase.transactionLog), testCase.algorithm, Options{}, "")
+		if err != nil {
+			t.Fatalf("Validate #%d: %s", idx, err.Error())
+		}
+		var formatted []string
+		for _, problem := range problems {
+			formatted = append(formatted, problem.String())
+		}
+		if !reflect.DeepEqual(formatted, testCase.expectedProblems) {
+			t.Errorf("Validate #%d: Expected problems %q ... got %q instead", idx, testCase.expectedProblems, formatted)
+		}
+	}
+
+	if _, err := Validate(strings.NewReader(""), "average", Options{WashSales: true}, ""); err == nil || !strings.Contains(err.Error(), "Wash sale detection is not supported by the average algorithm") {
+		t.Errorf("Validate: Expected an unsupported wash sale detection error ... got %v instead", err)
+	}
+}
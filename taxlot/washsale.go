@@ -0,0 +1,190 @@
+package taxlot
+
+import (
+	"sort"
+	"time"
+)
+
+// Buys of an asset within this many days before or after a sale of it at a loss make the sale a wash sale
+const washSaleDays = 30
+
+// A pendingWashLoss is the part of a loss disposal not yet matched with replacement shares, which later buys of the same
+// asset within washSaleDays after the sale are matched with
+type pendingWashLoss struct {
+	// Loss disposal, which is updated in place as replacement shares are found
+	disposal *Disposal
+	// Quantity of the disposal not yet matched with replacement shares
+	quantity Decimal
+}
+
+// Function to detect wash sales among the disposals realized by a sale, which are updated in place
+// Every loss is matched against the lots of the asset bought within washSaleDays before the sale in any account (earliest
+// first), other than the lot the loss was realized on; any quantity left unmatched stays pending for buys after the sale
+func (ledger *Ledger) washPrecedingBuys(disposals []Disposal) {
+	replacements := map[*Book][]Lot{}
+	for idx := range disposals {
+		disposal := &disposals[idx]
+		if disposal.Gain().Sign() >= 0 {
+			continue
+		}
+		loss := pendingWashLoss{disposal: disposal, quantity: disposal.Quantity}
+		windowStart := disposal.Sold.AddDate(0, 0, -washSaleDays)
+		for _, candidate := range ledger.washCandidates(disposal.Symbol) {
+			lot := candidate.lot
+			if loss.quantity.Sign() == 0 || lot.Quantity.Sign() == 0 || lot.Date.Before(windowStart) || lot.Date.After(disposal.Sold) || !isWashSaleCandidate(*lot, *disposal) {
+				continue
+			}
+			if replacement, split := washSaleReplacement(lot, &loss, disposal); split {
+				replacements[candidate.book] = append(replacements[candidate.book], replacement)
+			}
+		}
+		if loss.quantity.Sign() > 0 {
+			ledger.pendingLosses[disposal.Symbol] = append(ledger.pendingLosses[disposal.Symbol], loss)
+		}
+	}
+	for book, bookReplacements := range replacements {
+		book.addReplacements(bookReplacements)
+	}
+}
+
+// A washCandidate is an open lot that may replace the shares sold at a loss, along with the book holding it
+type washCandidate struct {
+	book *Book
+	lot  *Lot
+}
+
+// Function to list the open lots of the asset with the given symbol in every account, in chronological order (lots bought
+// on the same day are listed by account, in alphabetical order)
+// The lots are pointed to in place, so the books must not gain or lose lots while the candidates are in use
+func (ledger *Ledger) washCandidates(symbol string) []washCandidate {
+	var candidates []washCandidate
+	for _, account := range ledger.accountNames() {
+		book, ok := ledger.accounts[account][symbol]
+		if !ok {
+			continue
+		}
+		for idx := range book.lots {
+			candidates = append(candidates, washCandidate{book: book, lot: &book.lots[idx]})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].lot.Date.Before(candidates[j].lot.Date)
+	})
+	return candidates
+}
+
+// Function to match the pending losses of the asset against the most recent lot of book, which has just been bought (or
+// added to) in any account
+// Pending losses realized more than washSaleDays before the buy can no longer be matched, so they are dropped
+func (ledger *Ledger) washFollowingBuy(book *Book) {
+	lot := &book.lots[len(book.lots)-1]
+	var replacements []Lot
+	pending := ledger.pendingLosses[lot.Symbol][:0]
+	for _, loss := range ledger.pendingLosses[lot.Symbol] {
+		disposal := loss.disposal
+		if lot.Date.After(disposal.Sold.AddDate(0, 0, washSaleDays)) {
+			continue
+		}
+		if lot.Quantity.Sign() > 0 && isWashSaleCandidate(*lot, *disposal) {
+			if replacement, split := washSaleReplacement(lot, &loss, disposal); split {
+				replacements = append(replacements, replacement)
+			}
+		}
+		if loss.quantity.Sign() > 0 {
+			pending = append(pending, loss)
+		}
+	}
+	if len(pending) == 0 {
+		delete(ledger.pendingLosses, lot.Symbol)
+	} else {
+		ledger.pendingLosses[lot.Symbol] = pending
+	}
+	book.addReplacements(replacements)
+}
+
+// Function to drop the pending losses realized more than washSaleDays before date, which buys on or after date can no
+// longer match, so that losses on assets never bought again do not stay pending for good
+func (ledger *Ledger) dropExpiredLosses(date time.Time) {
+	for symbol, losses := range ledger.pendingLosses {
+		pending := losses[:0]
+		for _, loss := range losses {
+			if !loss.disposal.Sold.AddDate(0, 0, washSaleDays).Before(date) {
+				pending = append(pending, loss)
+			}
+		}
+		if len(pending) == 0 {
+			delete(ledger.pendingLosses, symbol)
+			continue
+		}
+		// The dropped losses are cleared, so that the disposals they point to can be freed
+		for idx := len(pending); idx < len(losses); idx++ {
+			losses[idx] = pendingWashLoss{}
+		}
+		ledger.pendingLosses[symbol] = pending
+	}
+}
+
+// Function to rescale the pending losses of the asset split by tx, as their units are matched against buys after the split
+func (ledger *Ledger) washSplit(tx Transaction) {
+	pending := ledger.pendingLosses[tx.Symbol][:0]
+	for _, loss := range ledger.pendingLosses[tx.Symbol] {
+		loss.quantity = loss.quantity.proRata(tx.SplitRatio.After, tx.SplitRatio.Before)
+		if loss.quantity.Sign() > 0 {
+			pending = append(pending, loss)
+		}
+	}
+	if len(pending) == 0 {
+		delete(ledger.pendingLosses, tx.Symbol)
+	} else {
+		ledger.pendingLosses[tx.Symbol] = pending
+	}
+}
+
+// Function to determine whether lot may replace the shares sold by disposal
+// Shares replace at most one wash sale, and a lot cannot replace shares sold from itself (lot ids are only unique within
+// an account)
+func isWashSaleCandidate(lot Lot, disposal Disposal) bool {
+	return !lot.washReplacement && (lot.ID != disposal.LotID || lot.Account != disposal.Account)
+}
+
+// Function to make (part of) lot the replacement for the unmatched part of loss: the disallowed portion of the loss is added
+// to the replacement's basis, and its holding period is extended by the holding period of the shares sold at a loss
+// If only part of lot is needed, the replacement is split off lot and returned (with split true) to be added to the book;
+// otherwise lot itself becomes the replacement. lot, loss and disposal are updated in place
+func washSaleReplacement(lot *Lot, loss *pendingWashLoss, disposal *Disposal) (replacement Lot, split bool) {
+	quantity := lot.Quantity
+	if loss.quantity.Cmp(quantity) < 0 {
+		quantity = loss.quantity
+		split = true
+	}
+	replacement = *lot
+	replacement.Quantity = quantity
+	replacement.Fee = lot.Fee.proRata(quantity, lot.Quantity)
+
+	// The loss still allowed is allocated in proportion to the quantity replaced, so that the disallowed portions add up exactly
+	disallowed := disposal.CostBasis.Sub(disposal.Proceeds).Sub(disposal.DisallowedLoss).proRata(quantity, loss.quantity)
+	loss.quantity = loss.quantity.Sub(quantity)
+	disposal.DisallowedLoss = disposal.DisallowedLoss.Add(disallowed)
+	replacement.Price = replacement.Price.Add(disallowed.Div(quantity))
+	replacement.HoldingStart = replacement.HoldingPeriodStart().Add(-disposal.Sold.Sub(disposal.HoldingStart))
+	replacement.washReplacement = true
+	if !split {
+		*lot = replacement
+		return Lot{}, false
+	}
+	lot.Quantity = lot.Quantity.Sub(quantity)
+	lot.Fee = lot.Fee.Sub(replacement.Fee)
+	return replacement, true
+}
+
+// Function to add replacement lots split off by washSaleReplacement to the book as lots of their own (with new ids),
+// sorting the book back to chronological order
+func (book *Book) addReplacements(replacements []Lot) {
+	if len(replacements) == 0 {
+		return
+	}
+	for _, replacement := range replacements {
+		book.add(replacement)
+	}
+	book.sortChronologically()
+}
@@ -0,0 +1,195 @@
+package taxlot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWashSales(t *testing.T) {
+	resultingLots, disposals, err := ProcessTransactionsWithOptions([]string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-03-01,buy,60.00,5.00000000",
+		"2021-03-10,sell,80.00,10.00000000",
+		"2021-03-20,buy,70.00,2.00000000",
+		"2021-05-01,buy,50.00,1.00000000",
+	}, "fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+
+	// The $200 loss on 10 units is disallowed for the 5 units bought before the sale and the 2 units bought after it;
+	// the buy on 2021-05-01 is more than 30 days after the sale
+	want := "1,2021-01-01,2021-03-10,10.00000000,1000.00,800.00,-60.00"
+	if len(disposals) != 1 || disposals[0].String() != want {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected a single disposal %s ... got %v instead", want, disposals)
+	}
+	if expected := mustParseDecimal("140"); disposals[0].DisallowedLoss.Cmp(expected) != 0 {
+		t.Errorf("ProcessTransactionsWithOptions: Expected disallowed loss of %s ... got %s instead", expected, disposals[0].DisallowedLoss)
+	}
+
+	// Replacement lots inherit the 68 day holding period of the units sold at a loss
+	expectedLots := []struct {
+		formatted    string
+		HoldingStart string
+	}{
+		{"2,2021-03-01,80.00,5.00000000", "2020-12-23"},
+		{"3,2021-03-20,90.00,2.00000000", "2021-01-11"},
+		{"4,2021-05-01,50.00,1.00000000", "2021-05-01"},
+	}
+	if len(resultingLots) != len(expectedLots) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %d lots back, got %v instead", len(expectedLots), resultingLots)
+	}
+	for idx, want := range expectedLots {
+		if got := resultingLots[idx].String(); got != want.formatted {
+			t.Errorf("ProcessTransactionsWithOptions: Expected resultingLots[%d].String() to be %s ... got %s instead", idx, want.formatted, got)
+		}
+		if got := resultingLots[idx].HoldingPeriodStart().Format(DateLayout); got != want.HoldingStart {
+			t.Errorf("ProcessTransactionsWithOptions: Expected resultingLots[%d] holding period to start on %s ... got %s instead", idx, want.HoldingStart, got)
+		}
+	}
+}
+
+func TestWashSalePartialReplacement(t *testing.T) {
+	resultingLots, disposals, err := ProcessTransactionsWithOptions([]string{
+		"2021-01-01,buy,100.00,10.00000000",
+		"2021-02-01,sell,50.00,4.00000000",
+		"2021-02-15,buy,60.00,10.00000000",
+		"2022-01-20,sell,120.00,4.00000000",
+	}, "hifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+
+	// Only 4 of the 10 units bought on 2021-02-15 replace the units sold at a loss, so they are split off into lot 3,
+	// which is sold first by hifo and is long-term thanks to the holding period carried over from lot 1
+	expectedDisposals := []struct {
+		formatted      string
+		DisallowedLoss Decimal
+		Term           string
+	}{
+		{"1,2021-01-01,2021-02-01,4.00000000,400.00,200.00,0.00", mustParseDecimal("200"), ShortTerm},
+		{"3,2021-02-15,2022-01-20,4.00000000,440.00,480.00,40.00", Decimal{}, LongTerm},
+	}
+	if len(disposals) != len(expectedDisposals) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %d disposals back, got %v instead", len(expectedDisposals), disposals)
+	}
+	for idx, want := range expectedDisposals {
+		if got := disposals[idx].String(); got != want.formatted {
+			t.Errorf("ProcessTransactionsWithOptions: Expected disposals[%d].String() to be %s ... got %s instead", idx, want.formatted, got)
+		}
+		if disposals[idx].DisallowedLoss.Cmp(want.DisallowedLoss) != 0 {
+			t.Errorf("ProcessTransactionsWithOptions: Expected disposals[%d] disallowed loss to be %s ... got %s instead", idx, want.DisallowedLoss, disposals[idx].DisallowedLoss)
+		}
+		if got := disposals[idx].Term(DefaultLongTermThreshold); got != want.Term {
+			t.Errorf("ProcessTransactionsWithOptions: Expected disposals[%d] to be %s-term ... got %s-term instead", idx, want.Term, got)
+		}
+	}
+
+	expectedLots := []string{"1,2021-01-01,100.00,6.00000000", "2,2021-02-15,60.00,6.00000000"}
+	if len(resultingLots) != len(expectedLots) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %d lots back, got %v instead", len(expectedLots), resultingLots)
+	}
+	for idx, want := range expectedLots {
+		if got := resultingLots[idx].String(); got != want {
+			t.Errorf("ProcessTransactionsWithOptions: Expected resultingLots[%d].String() to be %s ... got %s instead", idx, want, got)
+		}
+	}
+}
+
+func TestWashSalesAcrossAccounts(t *testing.T) {
+	resultingLots, disposals, err := ProcessTransactionsWithOptions([]string{
+		"2021-01-01,buy,2.00,100.00000000,X,,,,a",
+		"2021-01-05,buy,3.00,10.00000000,Y,,,,b",
+		"2021-01-10,sell,1.00,50.00000000,X,,,,a",
+		"2021-01-19,buy,1.00,60.00000000,X,,,,b",
+		"2021-01-20,buy,3.00,10.00000000,Y,,,,a",
+		"2021-01-25,sell,2.00,10.00000000,Y,,,,a",
+	}, "fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("ProcessTransactionsWithOptions: %s", err.Error())
+	}
+
+	// The loss on X is disallowed by the buy in account b after the sale (whose first lot shares its id with the lot sold),
+	// and the loss on Y by the buy in account b before the sale
+	expectedLosses := []string{"50", "10"}
+	if len(disposals) != len(expectedLosses) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %d disposals back, got %v instead", len(expectedLosses), disposals)
+	}
+	for idx, want := range expectedLosses {
+		if expected := mustParseDecimal(want); disposals[idx].DisallowedLoss.Cmp(expected) != 0 {
+			t.Errorf("ProcessTransactionsWithOptions: Expected disposals[%d] disallowed loss of %s ... got %s instead", idx, expected, disposals[idx].DisallowedLoss)
+		}
+	}
+
+	expectedLots := []string{
+		"1,2021-01-01,2.00,50.00000000,X,a",
+		"1,2021-01-19,1.00,10.00000000,X,b",
+		"2,2021-01-19,2.00,50.00000000,X,b",
+		"1,2021-01-05,4.00,10.00000000,Y,b",
+	}
+	if len(resultingLots) != len(expectedLots) {
+		t.Fatalf("ProcessTransactionsWithOptions: Expected %d lots back, got %v instead", len(expectedLots), resultingLots)
+	}
+	for idx, want := range expectedLots {
+		if got := resultingLots[idx].String(); got != want {
+			t.Errorf("ProcessTransactionsWithOptions: Expected resultingLots[%d].String() to be %s ... got %s instead", idx, want, got)
+		}
+	}
+}
+
+func TestSettledPendingLossesAreDropped(t *testing.T) {
+	ledger, err := NewLedger("fifo", Options{WashSales: true})
+	if err != nil {
+		t.Fatalf("NewLedger: %s", err.Error())
+	}
+	apply := func(rawTx string) {
+		tx, err := ParseTransaction(rawTx)
+		if err != nil {
+			t.Fatalf("ParseTransaction: %s", err.Error())
+		}
+		if _, err := ledger.Apply(tx); err != nil {
+			t.Fatalf("Apply (%s): %s", rawTx, err.Error())
+		}
+		ledger.TakeSettledDisposals()
+	}
+
+	// Losses on assets that are never bought again stay pending only until their disposals settle
+	symbols := []string{"A", "B", "C"}
+	for _, symbol := range symbols {
+		apply("2021-01-01,buy,100.00,1.00000000," + symbol)
+	}
+	for _, symbol := range symbols {
+		apply("2021-02-01,sell,50.00,1.00000000," + symbol)
+	}
+	if len(ledger.pendingLosses) != 3 {
+		t.Fatalf("TakeSettledDisposals: Expected pending losses on 3 assets ... got %v instead", ledger.pendingLosses)
+	}
+	apply("2021-03-03,buy,100.00,1.00000000,D")
+	if len(ledger.pendingLosses) != 3 {
+		t.Errorf("TakeSettledDisposals: Expected losses to stay pending for 30 days ... got %v instead", ledger.pendingLosses)
+	}
+	apply("2021-03-04,buy,100.00,1.00000000,D")
+	if len(ledger.pendingLosses) != 0 {
+		t.Errorf("TakeSettledDisposals: Expected the pending losses of settled disposals to be dropped ... got %v instead", ledger.pendingLosses)
+	}
+}
+
+func TestWashSalesDisabledByDefault(t *testing.T) {
+	_, disposals, err := ProcessTransactions([]string{"2021-01-01,buy,100.00,1.00000000", "2021-01-02,buy,100.00,1.00000000", "2021-01-03,sell,50.00,1.00000000"}, "fifo")
+	if err != nil {
+		t.Fatalf("ProcessTransactions: %s", err.Error())
+	}
+	if len(disposals) != 1 || !disposals[0].DisallowedLoss.IsZero() {
+		t.Errorf("ProcessTransactions: Expected a single disposal without a disallowed loss ... got %v instead", disposals)
+	}
+}
+
+func TestWashSalesUnsupportedAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{"average", "uk"} {
+		_, _, err := ProcessTransactionsWithOptions([]string{"2021-01-01,buy,100.00,1.00000000"}, algorithm, Options{WashSales: true})
+		expectedErrorSnippet := "Wash sale detection is not supported by the " + algorithm + " algorithm"
+		if err == nil || !strings.Contains(err.Error(), expectedErrorSnippet) {
+			t.Errorf("ProcessTransactionsWithOptions (%s): Expected error containing \"%s\" ... got \"%v\" instead", algorithm, expectedErrorSnippet, err)
+		}
+	}
+}